@@ -5,6 +5,7 @@ go 1.16
 require (
 	github.com/elazarl/goproxy v0.0.0-20210110162100-a92cc753f88e
 	github.com/spf13/cobra v1.1.3
+	golang.org/x/net v0.0.0-20200822124328-c89045814202
 	google.golang.org/grpc v1.39.0
 	google.golang.org/protobuf v1.27.1
 )
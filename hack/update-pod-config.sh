@@ -0,0 +1,33 @@
+#!/usr/bin/env bash
+#
+# Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+# Publishes the inner proxy image and regenerates the manifest embedded in
+# periscope, which pins it. Run this (with KO_DOCKER_REPO set) before
+# releasing any change to the Periscope service, and whenever ProtocolVersion
+# in pkg/periscope/version.go changes, after updating the periscope/protocol
+# annotation in config/remote-pod.yaml to match.
+
+set -euo pipefail
+
+cd "$(dirname "$0")/.."
+
+protocol=$(sed -n 's/^const ProtocolVersion = \([0-9]*\)$/\1/p' pkg/periscope/version.go)
+if ! grep -q "periscope/protocol: \"${protocol}\"" config/remote-pod.yaml; then
+  echo "config/remote-pod.yaml should have periscope/protocol: \"${protocol}\"" >&2
+  exit 1
+fi
+
+ko resolve -f config/remote-pod.yaml > pkg/remote/pod-config.yaml
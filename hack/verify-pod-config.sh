@@ -0,0 +1,30 @@
+#!/usr/bin/env bash
+#
+# Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+# Checks that the inner proxy image embedded in periscope speaks this
+# build's protocol, so that periscope --setup and periscope swap work without
+# --image. Run it before tagging a release; if it fails, run
+# hack/update-pod-config.sh.
+
+set -euo pipefail
+
+cd "$(dirname "$0")/.."
+
+protocol=$(sed -n 's/^const ProtocolVersion = \([0-9]*\)$/\1/p' pkg/periscope/version.go)
+if ! grep -q "periscope/protocol: \"${protocol}\"" pkg/remote/pod-config.yaml; then
+  echo "pkg/remote/pod-config.yaml pins an image which doesn't speak protocol ${protocol}; republish it with hack/update-pod-config.sh" >&2
+  exit 1
+fi
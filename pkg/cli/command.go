@@ -0,0 +1,82 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// noProxy lists the hosts which are always reached directly rather than
+// through the cluster.
+var noProxy = []string{"localhost", "127.0.0.1", "::1"}
+
+// proxyEnv returns the environment which sends a command's HTTP(S) requests
+// through the local proxy at addr, except to this machine and any hosts
+// already in $NO_PROXY.
+func proxyEnv(addr string) []string {
+	url := "http://" + addr
+	hosts := append([]string{}, noProxy...)
+	for _, v := range []string{os.Getenv("NO_PROXY"), os.Getenv("no_proxy")} {
+		for _, h := range strings.Split(v, ",") {
+			if h = strings.TrimSpace(h); h != "" && !contains(hosts, h) {
+				hosts = append(hosts, h)
+			}
+		}
+	}
+	skip := strings.Join(hosts, ",")
+	return []string{
+		"http_proxy=" + url, "HTTP_PROXY=" + url,
+		"https_proxy=" + url, "HTTPS_PROXY=" + url,
+		"no_proxy=" + skip, "NO_PROXY=" + skip,
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// runCommand runs args with env added to periscope's own, and returns its
+// exit code, as a shell would.
+func runCommand(args []string, env []string) int {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), env...)
+	err := cmd.Run()
+	var exit *exec.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exit):
+		if status, ok := exit.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exit.ExitCode()
+	default:
+		log.Print(err)
+		return 127
+	}
+}
@@ -0,0 +1,195 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// sessionState describes a running periscope, for periscope env.
+type sessionState struct {
+	// The local proxy's address.
+	Address string `json:"address"`
+	Session string `json:"session,omitempty"`
+	Context string `json:"context,omitempty"`
+	Cluster string `json:"cluster,omitempty"`
+	// The Service in the cluster whose requests come back here, if any.
+	Service string `json:"service,omitempty"`
+}
+
+// statePath returns where the state of the session named name is kept.
+func statePath(name string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		name = "default"
+	}
+	return filepath.Join(dir, "periscope", "session-"+name+".json"), nil
+}
+
+// writeState records state for periscope env, returning a function which
+// removes it again.
+func writeState(state sessionState) (func() error, error) {
+	path, err := statePath(state.Session)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+	return func() error { return os.Remove(path) }, nil
+}
+
+// readState returns the state of the running session named name.
+func readState(name string) (*sessionState, error) {
+	path, err := statePath(name)
+	if err != nil {
+		return nil, err
+	}
+	session := "periscope session"
+	if name != "" {
+		session = fmt.Sprintf("periscope session %q", name)
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("No %s is running", session)
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &sessionState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("Unable to parse %s: %w", path, err)
+	}
+	// The file outlives a periscope which didn't exit cleanly.
+	conn, err := net.DialTimeout("tcp", state.Address, time.Second)
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("No %s is running; the last one at %s exited", session, state.Address)
+	}
+	conn.Close()
+	return state, nil
+}
+
+// stateEnv returns the variables periscope env sets, in order.
+func stateEnv(state *sessionState) [][2]string {
+	var vars [][2]string
+	for _, kv := range proxyEnv(state.Address) {
+		i := strings.Index(kv, "=")
+		vars = append(vars, [2]string{kv[:i], kv[i+1:]})
+	}
+	return append(vars,
+		[2]string{"PERISCOPE_ADDRESS", state.Address},
+		[2]string{"PERISCOPE_SESSION", state.Session},
+		[2]string{"PERISCOPE_CONTEXT", state.Context},
+		[2]string{"PERISCOPE_CLUSTER", state.Cluster},
+		[2]string{"PERISCOPE_SERVICE", state.Service},
+	)
+}
+
+// quoters format a variable assignment for each shell periscope env knows.
+var quoters = map[string]func(name string, value string) string{
+	"sh": func(name string, value string) string {
+		return fmt.Sprintf("export %s='%s'", name, strings.ReplaceAll(value, "'", `'\''`))
+	},
+	"fish": func(name string, value string) string {
+		value = strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value)
+		return fmt.Sprintf("set -gx %s '%s'", name, value)
+	},
+	"powershell": func(name string, value string) string {
+		return fmt.Sprintf("$env:%s = '%s'", name, strings.ReplaceAll(value, "'", "''"))
+	},
+}
+
+var envShell *string
+
+var EnvCmd = &cobra.Command{
+	Use:   "env",
+	Short: "Print the proxy environment of a running periscope for your shell",
+	Long: `Print commands setting http_proxy and the like to use a running periscope
+(see --session), along with its kubeconfig context and cluster and the
+Service whose requests come back to it:
+
+  eval "$(periscope env)"                      # bash, zsh
+  periscope env --shell fish | source          # fish
+  periscope env --shell powershell | Invoke-Expression
+
+--shell json prints the same as a JSON object.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		shell := *envShell
+		if shell == "" {
+			shell = "sh"
+			if filepath.Base(os.Getenv("SHELL")) == "fish" {
+				shell = "fish"
+			}
+		}
+		switch shell {
+		case "bash", "zsh":
+			shell = "sh"
+		case "pwsh":
+			shell = "powershell"
+		}
+		quote, ok := quoters[shell]
+		if shell != "json" && !ok {
+			log.Printf("Unknown --shell %q, expected sh, bash, zsh, fish, powershell or json", *envShell)
+			os.Exit(2)
+		}
+
+		state, err := readState(*sessionName)
+		if err != nil {
+			log.Print(err)
+			os.Exit(1)
+		}
+		vars := stateEnv(state)
+		if shell == "json" {
+			obj := make(map[string]string, len(vars))
+			for _, v := range vars {
+				obj[v[0]] = v[1]
+			}
+			out, _ := json.MarshalIndent(obj, "", "  ")
+			fmt.Println(string(out))
+			return
+		}
+		for _, v := range vars {
+			fmt.Println(quote(v[0], v[1]))
+		}
+	},
+}
+
+func init() {
+	envShell = EnvCmd.Flags().String("shell", "", "The syntax to print: sh (bash, zsh), fish, powershell or json; by default from $SHELL")
+	RootCmd.AddCommand(EnvCmd)
+}
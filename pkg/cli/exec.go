@@ -0,0 +1,45 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var ExecCmd = &cobra.Command{
+	Use:   "exec -- command [args...]",
+	Short: "Run a command with its HTTP requests sent through periscope",
+	Long: `Connect to the cluster and run a command with http_proxy, https_proxy and
+NO_PROXY (this machine, plus anything already in $NO_PROXY) set to use
+periscope, e.g. for integration tests:
+
+  periscope exec -- go test ./integration/...
+
+periscope disconnects when the command exits, and exits with its exit code.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runProxy(*forwards, proxyOptions{
+			command: func(addr string) int {
+				return runCommand(args, proxyEnv(addr))
+			},
+		})
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(ExecCmd)
+}
@@ -0,0 +1,40 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var ForwardCmd = &cobra.Command{
+	Use:   "forward [bind_address:]port:host:hostport...",
+	Short: "Forward local ports to addresses in the cluster",
+	Long: `Forward local TCP ports to addresses in the cluster, like ssh -L,
+for tools which don't use an HTTP proxy (psql, redis-cli, etc):
+
+  periscope forward 5432:postgres.db:5432 6379:redis.cache:6379
+
+The local HTTP proxy runs alongside, over the same connection.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runProxy(append(*forwards, args...), proxyOptions{})
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(ForwardCmd)
+}
@@ -19,15 +19,22 @@ package cli
 import (
 	"log"
 	"os"
+	"time"
 
+	"github.com/evankanderson/periscope/pkg/periscope"
 	"github.com/evankanderson/periscope/pkg/remoteproxy"
 	"github.com/spf13/cobra"
 )
 
 // Flags
 var (
-	httpPort *int
-	grpcPort *int
+	httpPort       *int
+	grpcPort       *int
+	timeout        *time.Duration
+	grace          *time.Duration
+	queue          *int
+	fallback       *string
+	defaultSession *string
 )
 
 var InnerCmd = &cobra.Command{
@@ -35,11 +42,20 @@ var InnerCmd = &cobra.Command{
 	Short: "Periscope inner proxy",
 	Long:  "Inner proxy that receives requests from the outer periscope instance",
 	Run: func(cmd *cobra.Command, args []string) {
-		proxy, err := remoteproxy.NewLocalProxy(*httpPort, *grpcPort)
+		proxy, err := remoteproxy.NewLocalProxy(remoteproxy.Config{
+			HTTPPort:       *httpPort,
+			GRPCPort:       *grpcPort,
+			Timeout:        *timeout,
+			Grace:          *grace,
+			QueueSize:      *queue,
+			Fallback:       *fallback,
+			DefaultSession: *defaultSession,
+		})
 		if err != nil {
 			log.Printf("Failed to initialize: %s", err)
 			os.Exit(2)
 		}
+		log.Printf("periscope %s (protocol %d)", periscope.BuildVersion(), periscope.ProtocolVersion)
 		log.Printf("HTTP Proxy on :%d, GRPC server on %d", *httpPort, *grpcPort)
 		if err := proxy.Start(); err != nil {
 			log.Printf("Failed to start services: %s", err)
@@ -51,6 +67,11 @@ var InnerCmd = &cobra.Command{
 func init() {
 	httpPort = InnerCmd.Flags().IntP("port", "p", 8080, "Local HTTP proxy port out of the cluster")
 	grpcPort = InnerCmd.Flags().IntP("server", "s", 5000, "GRPC proxy service port for connection")
+	grace = InnerCmd.Flags().Duration("grace", 0, "How long requests out of the cluster wait for periscope to reconnect before failing")
+	queue = InnerCmd.Flags().Int("queue", 100, "The most requests which may wait for periscope to reconnect at once; 0 for no limit")
+	defaultSession = InnerCmd.Flags().String("default-session", "", "The session requests which don't name one go to; by default, whoever connects without --session")
+	fallback = InnerCmd.Flags().String("fallback", "", "If set, URL or host:port to send requests out of the cluster to while periscope isn't connected or its target is unhealthy")
+	timeout = InnerCmd.Flags().Duration("timeout", time.Minute, "How long requests out of the cluster wait for a response to start; 0 to wait as long as the caller")
 
 	//	RootCmd.AddCommand(InnerCmd)
 }
@@ -0,0 +1,84 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/evankanderson/periscope/pkg/remote"
+	"github.com/spf13/cobra"
+)
+
+var interceptPort *int
+
+var InterceptCmd = &cobra.Command{
+	Use:   "intercept svc/<name>",
+	Short: "Send an existing Service's requests to the local target",
+	Long: `Send the requests to an existing Service in the cluster to the local
+target (-t) instead of its pods, until interrupted:
+
+  periscope intercept svc/payments --service-port 8080 --target localhost:3000
+
+The Service's selector is pointed at periscope-remote-proxy, and its original
+selector and ports are kept in the periscope/intercepted annotation until
+they are restored on exit. If periscope doesn't get to restore them (e.g. it
+crashed), the next intercept of the Service does.
+
+Meanwhile, svc/periscope-original-<name> selects the Service's pods, and is
+the --fallback unless another is given.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name, err := remote.ParseService(args[0])
+		if err != nil {
+			log.Print(err)
+			os.Exit(2)
+		}
+		if namesService(*fallbackURL, name) {
+			log.Printf("svc/%s can't be the --fallback while it is intercepted, as it leads back here; leave --fallback out to use its pods", name)
+			os.Exit(2)
+		}
+		runProxy(*forwards, proxyOptions{service: name, fallback: remote.OriginalService(name), changes: []clusterChange{func() (func() error, error) {
+			log.Printf("Intercepting svc/%s...", name)
+			return remote.InterceptService(name, *interceptPort)
+		}}})
+	},
+}
+
+// namesService reports whether the fallback URL (or host:port) is the
+// Service name, in this or (to be safe) another namespace.
+func namesService(fallback string, name string) bool {
+	if fallback == "" {
+		return false
+	}
+	if !strings.Contains(fallback, "://") {
+		fallback = "http://" + fallback
+	}
+	u, err := url.Parse(fallback)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	return host == name || strings.HasPrefix(host, name+".")
+}
+
+func init() {
+	interceptPort = InterceptCmd.Flags().Int("service-port", 0, "The Service port to intercept; may be left out if it has only one")
+	RootCmd.AddCommand(InterceptCmd)
+}
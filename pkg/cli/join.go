@@ -0,0 +1,63 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"log"
+	"os"
+
+	"github.com/evankanderson/periscope/pkg/remote"
+	"github.com/spf13/cobra"
+)
+
+var joinPort *int
+
+var JoinCmd = &cobra.Command{
+	Use:   "join svc/<name>",
+	Short: "Send a share of an existing Service's requests to the local target",
+	Long: `Add the local target (-t) to the backends of an existing Service in the
+cluster, alongside its pods, until interrupted:
+
+  periscope join svc/payments --service-port 8080 --target localhost:3000
+
+periscope-remote-proxy is added to the Service's endpoints with an
+EndpointSlice named periscope-<name>, so it gets one pod's share of the
+requests, which it sends here. The slice is deleted on exit; if periscope
+doesn't get to (e.g. it crashed), the next join of the Service replaces it,
+or it can be deleted with kubectl.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name, err := remote.ParseService(args[0])
+		if err != nil {
+			log.Print(err)
+			os.Exit(2)
+		}
+		if namesService(*fallbackURL, name) {
+			log.Printf("svc/%s can't be the --fallback while periscope is one of its endpoints, as it leads back here", name)
+			os.Exit(2)
+		}
+		runProxy(*forwards, proxyOptions{service: name, changes: []clusterChange{func() (func() error, error) {
+			log.Printf("Joining svc/%s...", name)
+			return remote.JoinService(name, *joinPort)
+		}}})
+	},
+}
+
+func init() {
+	joinPort = JoinCmd.Flags().Int("service-port", 0, "The Service port to join; may be left out if it has only one")
+	RootCmd.AddCommand(JoinCmd)
+}
@@ -20,6 +20,9 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
 
 	"github.com/evankanderson/periscope/pkg/localproxy"
 	"github.com/evankanderson/periscope/pkg/remote"
@@ -32,7 +35,20 @@ var (
 	port         *int
 	grpcServer   *string
 	target       *string
+	healthPath   *string
+	sessionName  *string
+	rules        *[]string
+	fallbackURL  *string
+	mirror       *bool
+	shadow       *bool
+	shadowReport *string
+	shadowHeader *[]string
+	shadowIgnore *[]string
 	clusterSetup *bool
+	innerImage   *string
+	forwards     *[]string
+	revForwards  *[]string
+	udpForwards  *[]string
 )
 
 // RootCmd represents the base command when called without any subcommands
@@ -44,41 +60,251 @@ cluster-local services from local tests / curl:
 
 This commands depends on your local kubeconfig environment to set up
 the proxy on the cluster; it prints an HTTP_PROXY value for your shell
-and continues running proxying traffic until terminated.`,
+and continues running proxying traffic until terminated. To run a
+command (or a shell) with it set instead, use periscope exec (or
+periscope shell).`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	Run: func(cmd *cobra.Command, args []string) {
-		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
-		defer stop()
-		if err := remote.EnsureTools(); err != nil {
+		runProxy(*forwards, proxyOptions{})
+	},
+}
+
+// sessionNames must be usable as a Host label and a cookie value.
+var sessionNames = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// A clusterChange is made to the cluster, and undone by the function it
+// returns when periscope exits.
+type clusterChange func() (func() error, error)
+
+// proxyOptions are how subcommands vary runProxy.
+type proxyOptions struct {
+	// The pod running the inner proxy, and its GRPC port, if not
+	// periscope-remote-proxy.
+	pod      string
+	grpcPort int
+	// Made before connecting to the pod, e.g. to start it.
+	setup []clusterChange
+	// Made once the pod is reachable.
+	changes []clusterChange
+	// Forwarded back like -R, but already reachable in the cluster.
+	reverseForwards []localproxy.ReverseForward
+	// The Service whose requests come back here, if not
+	// periscope-remote-proxy.
+	service string
+	// The fallback to use if --fallback isn't given.
+	fallback string
+	// If set, run once the local proxy is listening at addr, after which
+	// periscope exits with the code it returns.
+	command func(addr string) int
+}
+
+// runProxy connects to the cluster and runs the local proxy along with the
+// given `-L` style forwards until interrupted, making changes while it runs.
+func runProxy(forwardSpecs []string, opts proxyOptions) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	cfg := localproxy.Config{Port: *port, Target: *target, HealthPath: *healthPath, Session: *sessionName, Mirror: *mirror, Fallback: *fallbackURL}
+	if cfg.Fallback == "" {
+		cfg.Fallback = opts.fallback
+	}
+	if cfg.Session != "" && !sessionNames.MatchString(cfg.Session) {
+		log.Printf("Invalid --session %q: use lower case letters, digits and '-'", cfg.Session)
+		os.Exit(2)
+	}
+	for _, spec := range forwardSpecs {
+		f, err := localproxy.ParseForward(spec)
+		if err != nil {
+			log.Print(err)
+			os.Exit(2)
+		}
+		cfg.Forwards = append(cfg.Forwards, f)
+	}
+	for _, spec := range *udpForwards {
+		f, err := localproxy.ParseForward(spec)
+		if err != nil {
 			log.Print(err)
 			os.Exit(2)
 		}
+		cfg.UDPForwards = append(cfg.UDPForwards, f)
+	}
+	for _, spec := range *rules {
+		rule, err := localproxy.ParseRule(spec)
+		if err != nil {
+			log.Print(err)
+			os.Exit(2)
+		}
+		cfg.Rules = append(cfg.Rules, rule)
+	}
+	if *shadow {
+		cfg.Mirror = true
+	}
+	var exposed []int
+	for _, spec := range *revForwards {
+		f, err := localproxy.ParseReverseForward(spec)
+		if err != nil {
+			log.Print(err)
+			os.Exit(2)
+		}
+		cfg.ReverseForwards = append(cfg.ReverseForwards, f)
+		exposed = append(exposed, f.Port)
+	}
+	cfg.ReverseForwards = append(cfg.ReverseForwards, opts.reverseForwards...)
+	if err := remote.EnsureTools(); err != nil {
+		log.Print(err)
+		os.Exit(2)
+	}
 
-		if *clusterSetup {
-			log.Print("Setting up pod on remote cluster...")
-			if err := remote.EnsureForwarder(); err != nil {
+	// Undo the changes however periscope exits, including on interrupt.
+	// lock is held while a change is made, so that an interrupt waits for
+	// it to be made (or fail) and then undoes it.
+	var lock sync.Mutex
+	var undos []func() error
+	undoLocked := func() {
+		for len(undos) > 0 {
+			u := undos[len(undos)-1]
+			undos = undos[:len(undos)-1]
+			if err := u(); err != nil {
 				log.Print(err)
-				os.Exit(3)
 			}
 		}
-
-		if *grpcServer == "" {
-			log.Print("Connecting to pod on cluster to forward...")
-			endpoint, err, done := remote.StartForward(ctx, "periscope-remote-proxy", 5000)
+	}
+	undo := func() {
+		lock.Lock()
+		defer lock.Unlock()
+		undoLocked()
+	}
+	// exit keeps hold of lock, so that no change is made after the undos.
+	exit := func(code int) {
+		lock.Lock()
+		undoLocked()
+		os.Exit(code)
+	}
+	apply := func(changes []clusterChange) {
+		for _, change := range changes {
+			lock.Lock()
+			u, err := change()
+			if err == nil {
+				undos = append(undos, u)
+			}
+			lock.Unlock()
 			if err != nil {
 				log.Print(err)
-				os.Exit(4)
+				exit(3)
 			}
-			defer done()
-			*grpcServer = endpoint
 		}
+	}
+	// A command is interrupted along with periscope, which exits after it.
+	if opts.command == nil {
+		go func() {
+			<-ctx.Done()
+			log.Print("Interrupted, cleaning up...")
+			exit(1)
+		}()
+	}
 
-		if err := localproxy.StartLocalProxy(*port, *target, *grpcServer); err != nil {
-			log.Printf("Failed to start proxy: %s\n", err)
+	if *shadow {
+		report, err := localproxy.OpenShadow(localproxy.ShadowConfig{Report: *shadowReport, Headers: *shadowHeader, Ignore: *shadowIgnore})
+		if err != nil {
+			log.Print(err)
 			os.Exit(1)
 		}
-	},
+		// Summarise and close the report on interrupt too.
+		lock.Lock()
+		undos = append(undos, report.Close)
+		lock.Unlock()
+		cfg.Shadow = report
+	}
+
+	if *clusterSetup {
+		log.Print("Setting up pod on remote cluster...")
+		if err := remote.EnsureForwarder(*innerImage); err != nil {
+			log.Print(err)
+			os.Exit(3)
+		}
+	}
+	apply(opts.setup)
+
+	if *grpcServer == "" {
+		if len(exposed) > 0 {
+			apply([]clusterChange{func() (func() error, error) { return remote.ExposePorts(exposed) }})
+		}
+		pod, grpcPort := "periscope-remote-proxy", 5000
+		if opts.pod != "" {
+			pod, grpcPort = opts.pod, opts.grpcPort
+		}
+		log.Print("Connecting to pod on cluster to forward...")
+		forwardCtx, cancelForward := context.WithCancel(ctx)
+		endpoint, err, done := remote.StartForward(forwardCtx, pod, grpcPort)
+		if err != nil {
+			cancelForward()
+			log.Print(err)
+			exit(4)
+		}
+		// exit doesn't run deferred calls, so stop kubectl port-forward as
+		// an undo.
+		lock.Lock()
+		undos = append(undos, func() error {
+			cancelForward()
+			done()
+			return nil
+		})
+		lock.Unlock()
+		*grpcServer = endpoint
+	}
+
+	cfg.Server = *grpcServer
+	apply(opts.changes)
+
+	// Record the session for periscope env once the proxy is listening.
+	state := sessionState{Session: cfg.Session, Service: opts.service}
+	if state.Service == "" && opts.pod == "" {
+		state.Service = "periscope-remote-proxy"
+	}
+	if kubeContext, cluster, err := remote.CurrentContext(); err == nil {
+		state.Context, state.Cluster = kubeContext, cluster
+	}
+	announce := func(addr string) {
+		state.Address = addr
+		u, err := writeState(state)
+		if err != nil {
+			log.Printf("Unable to record the session for periscope env: %s", err)
+			return
+		}
+		lock.Lock()
+		undos = append(undos, u)
+		lock.Unlock()
+	}
+
+	if opts.command != nil {
+		ready := make(chan string, 1)
+		cfg.Ready = func(addr string) {
+			announce(addr)
+			ready <- addr
+		}
+		failed := make(chan error, 1)
+		go func() { failed <- localproxy.StartLocalProxy(cfg) }()
+		select {
+		case addr := <-ready:
+			go func() {
+				log.Printf("Proxy stopped: %v", <-failed)
+			}()
+			exit(opts.command(addr))
+		case err := <-failed:
+			log.Printf("Failed to start proxy: %s\n", err)
+			exit(1)
+		}
+	}
+	cfg.Ready = func(addr string) {
+		announce(addr)
+		log.Printf("For your shell: export %s", strings.Join(proxyEnv(addr), " "))
+		log.Print(`Or: eval "$(periscope env)"`)
+	}
+	if err := localproxy.StartLocalProxy(cfg); err != nil {
+		log.Printf("Failed to start proxy: %s\n", err)
+		exit(1)
+	}
+	undo()
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -94,10 +320,21 @@ func init() {
 
 	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.periscope.yaml)")
 
-	// Cobra also supports local flags, which will only run
-	// when this action is called directly.
-	port = RootCmd.Flags().IntP("port", "p", 6080, "Local proxy port to listen on.")
-	target = RootCmd.Flags().StringP("target", "t", "", "If set, local address to proxy requests back to")
-	grpcServer = RootCmd.Flags().StringP("server", "s", "", "Remote periscope to connect to")
-	clusterSetup = RootCmd.Flags().Bool("setup", false, "Set up components on the cluster")
+	port = RootCmd.PersistentFlags().IntP("port", "p", 6080, "Local proxy port to listen on.")
+	target = RootCmd.PersistentFlags().StringP("target", "t", "", "If set, local address to proxy requests back to")
+	healthPath = RootCmd.PersistentFlags().String("health-path", "", "If set, path on the target to GET to check its health; otherwise, the target is healthy if it accepts connections")
+	sessionName = RootCmd.PersistentFlags().String("session", "", "If set, a name to share the cluster's periscope with others under; only requests for this session (by X-Periscope-Session header, periscope-session cookie or Host prefix) come back here")
+	rules = RootCmd.PersistentFlags().StringArray("rule", nil, "Only take requests out of the cluster matching this rule, as method=GET,path=/api/v2/*,header=X-Debug:me,percent=10, leaving the rest to the --fallback. May be repeated.")
+	fallbackURL = RootCmd.PersistentFlags().String("fallback", "", "URL or host:port in the cluster (e.g. the real Service) to send requests this session doesn't take to, and those which arrive while it is disconnected or its target is down; by default the inner proxy's --fallback")
+	mirror = RootCmd.PersistentFlags().Bool("mirror", false, "Only take copies of requests out of the cluster (matching --rule), which the --fallback still answers")
+	shadow = RootCmd.PersistentFlags().Bool("shadow", false, "Like --mirror, but also compare the target's responses with the --fallback's, writing the differences to --shadow-report")
+	shadowReport = RootCmd.PersistentFlags().String("shadow-report", "periscope-shadow.jsonl", "File to append a line of JSON to for each --shadow comparison")
+	shadowHeader = RootCmd.PersistentFlags().StringArray("shadow-header", []string{"Content-Type"}, "Response header to compare with --shadow. May be repeated.")
+	shadowIgnore = RootCmd.PersistentFlags().StringArray("shadow-ignore", nil, "Path in JSON bodies to leave out of --shadow comparisons, e.g. meta.requestId or items.*.createdAt. May be repeated.")
+	grpcServer = RootCmd.PersistentFlags().StringP("server", "s", "", "Remote periscope to connect to")
+	clusterSetup = RootCmd.PersistentFlags().Bool("setup", false, "Set up components on the cluster")
+	innerImage = RootCmd.PersistentFlags().String("image", "", "The inner proxy image for --setup and swap to run, e.g. built with \"ko build ./cmd/inner\"; by default the one built into this release")
+	forwards = RootCmd.PersistentFlags().StringArrayP("local-forward", "L", nil, "Forward a local port to the cluster, as [bind_address:]port:host:hostport. May be repeated.")
+	revForwards = RootCmd.PersistentFlags().StringArrayP("remote-forward", "R", nil, "Forward a port on periscope-remote-proxy to a local address, as port:host:hostport. May be repeated.")
+	udpForwards = RootCmd.PersistentFlags().StringArrayP("udp-forward", "U", nil, "Forward a local UDP port to the cluster, as [bind_address:]port:host:hostport. May be repeated.")
 }
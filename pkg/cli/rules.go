@@ -0,0 +1,128 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/evankanderson/periscope/pkg/localproxy"
+	"github.com/evankanderson/periscope/pkg/periscope"
+	"github.com/evankanderson/periscope/pkg/remote"
+	"github.com/spf13/cobra"
+)
+
+var clearRules *bool
+
+var RulesCmd = &cobra.Command{
+	Use:   "rules [rule...]",
+	Short: "Show or change which requests out of the cluster come here",
+	Long: `Show or change the rules of a running session (see --session), which
+pick the requests out of the cluster to send to it. The inner proxy sends
+the rest to the session's --fallback, or its own. Rules take the form
+
+  method=GET,path=/api/v2/*,header=X-Debug:me,percent=10
+
+where every part is optional, and a request is taken if any rule matches.
+With --mirror, copies of the matching requests are taken instead, and the
+fallback still answers them. With no rules, --mirror or --fallback, prints
+the current ones; --clear takes every request again.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var parsed []*periscope.Rule
+		for _, spec := range args {
+			rule, err := localproxy.ParseRule(spec)
+			if err != nil {
+				log.Print(err)
+				os.Exit(2)
+			}
+			parsed = append(parsed, rule)
+		}
+		if *clearRules && (len(parsed) > 0 || *mirror) {
+			log.Print("--clear can't be combined with rules or --mirror")
+			os.Exit(2)
+		}
+
+		if err := showRules(parsed); err != nil {
+			log.Print(err)
+			os.Exit(1)
+		}
+	},
+}
+
+// showRules replaces the session's rules with rules if they (or --mirror,
+// --fallback or --clear) are given, and prints the rules in effect. The
+// session's fallback is kept unless --fallback is given.
+func showRules(rules []*periscope.Rule) error {
+	server := *grpcServer
+	if server == "" {
+		if err := remote.EnsureTools(); err != nil {
+			return err
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		endpoint, err, done := remote.StartForward(ctx, "periscope-remote-proxy", 5000)
+		if err != nil {
+			cancel()
+			return err
+		}
+		defer func() {
+			cancel()
+			done()
+		}()
+		server = endpoint
+	}
+
+	current, err := localproxy.GetRules(server, *sessionName)
+	if err != nil {
+		return err
+	}
+	if len(rules) > 0 || *mirror || *fallbackURL != "" || *clearRules {
+		// Whether the running periscope compares the copies isn't up to
+		// this one.
+		set := &periscope.Rules{Session: *sessionName, Rules: rules, Mirror: *mirror, Shadow: *mirror && current.Shadow, Fallback: current.Fallback}
+		if *fallbackURL != "" {
+			set.Fallback = *fallbackURL
+		}
+		if err := localproxy.SetRules(server, set); err != nil {
+			return err
+		}
+		if current, err = localproxy.GetRules(server, *sessionName); err != nil {
+			return err
+		}
+	}
+	switch {
+	case current.Mirror && len(current.Rules) == 0:
+		fmt.Println("Copies of all requests for the session are taken.")
+	case current.Mirror:
+		fmt.Println("Copies of requests for the session matching these are taken:")
+	case len(current.Rules) == 0:
+		fmt.Println("All requests for the session are taken.")
+	}
+	for _, rule := range current.Rules {
+		fmt.Println(localproxy.FormatRule(rule))
+	}
+	if current.Fallback != "" {
+		fmt.Printf("The session's fallback is %s.\n", current.Fallback)
+	}
+	return nil
+}
+
+func init() {
+	clearRules = RulesCmd.Flags().Bool("clear", false, "Remove the rules, taking every request for the session")
+	RootCmd.AddCommand(RulesCmd)
+}
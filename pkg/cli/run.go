@@ -0,0 +1,80 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"log"
+	"os"
+
+	"github.com/evankanderson/periscope/pkg/remote"
+	"github.com/spf13/cobra"
+)
+
+var (
+	runFrom      *string
+	runContainer *string
+)
+
+var RunCmd = &cobra.Command{
+	Use:   "run --from deploy/<name> -- command [args...]",
+	Short: "Run a command with a workload's environment and the proxy",
+	Long: `Run a command locally with the environment of a Deployment's container,
+and its HTTP requests sent through periscope:
+
+  periscope run --from deploy/orders -- go run ./cmd/orders
+
+The container's env and envFrom are resolved, including the ConfigMaps and
+Secrets they refer to. Its ConfigMap and Secret volumes are written under a
+temporary directory at their mount paths, named by $PERISCOPE_ROOT, which is
+removed when the command exits. periscope exits with the command's exit
+code.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name, err := remote.ParseDeployment(*runFrom)
+		if err != nil {
+			log.Print(err)
+			os.Exit(2)
+		}
+		var env []string
+		importEnv := func() (func() error, error) {
+			dir, err := os.MkdirTemp("", "periscope-run-")
+			if err != nil {
+				return nil, err
+			}
+			cleanup := func() error { return os.RemoveAll(dir) }
+			log.Printf("Importing the environment of deploy/%s...", name)
+			if env, err = remote.ImportWorkload(name, *runContainer, dir); err != nil {
+				cleanup()
+				return nil, err
+			}
+			return cleanup, nil
+		}
+		runProxy(*forwards, proxyOptions{
+			setup: []clusterChange{importEnv},
+			command: func(addr string) int {
+				return runCommand(args, append(env, proxyEnv(addr)...))
+			},
+		})
+	},
+}
+
+func init() {
+	runFrom = RunCmd.Flags().String("from", "", "The Deployment to take the environment of, as deploy/<name>")
+	RunCmd.MarkFlagRequired("from")
+	runContainer = RunCmd.Flags().String("container", "", "The container to take the environment of; by default the first")
+	RootCmd.AddCommand(RunCmd)
+}
@@ -0,0 +1,53 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"log"
+	"os"
+	"runtime"
+
+	"github.com/spf13/cobra"
+)
+
+var ShellCmd = &cobra.Command{
+	Use:   "shell",
+	Short: "Start a shell with its HTTP requests sent through periscope",
+	Long: `Connect to the cluster and start your shell ($SHELL) with the same proxy
+environment as periscope exec, and $PERISCOPE_SHELL set (e.g. for your
+prompt). periscope disconnects when the shell exits.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		shell := os.Getenv("SHELL")
+		if shell == "" {
+			shell = "/bin/sh"
+			if runtime.GOOS == "windows" {
+				shell = os.Getenv("COMSPEC")
+			}
+		}
+		runProxy(*forwards, proxyOptions{
+			command: func(addr string) int {
+				log.Printf("Starting %s; exit it to disconnect", shell)
+				return runCommand([]string{shell}, append(proxyEnv(addr), "PERISCOPE_SHELL=1"))
+			},
+		})
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(ShellCmd)
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cli
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	"github.com/evankanderson/periscope/pkg/localproxy"
+	"github.com/evankanderson/periscope/pkg/remote"
+	"github.com/spf13/cobra"
+)
+
+var swapPort *int
+
+var SwapCmd = &cobra.Command{
+	Use:   "swap deploy/<name>",
+	Short: "Replace a Deployment's pods with the local target",
+	Long: `Replace the pods of a Deployment in the cluster with an inner proxy pod,
+for callers which pick its pods by label or use a headless Service, until
+interrupted:
+
+  periscope swap deploy/orders --target localhost:8080
+
+The Deployment is scaled to zero, keeping its replicas in the
+periscope/swapped annotation, and pod/periscope-swap-<name> is started with
+its labels, ports and service account. HTTP requests to --http-port (by default
+the first port) go to --target, and the other TCP ports are forwarded to the
+same ports on the target's host. On exit the Deployment is scaled back; if
+periscope doesn't get to (e.g. it crashed), the next swap of it does.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name, err := remote.ParseDeployment(args[0])
+		if err != nil {
+			log.Print(err)
+			os.Exit(2)
+		}
+		if *grpcServer != "" {
+			log.Print("--server can't be combined with swap, which starts its own pod")
+			os.Exit(2)
+		}
+		// -R would listen on the swap pod, which no Service sends the port
+		// to; the pod already forwards the Deployment's own ports.
+		if len(*revForwards) > 0 {
+			log.Print("-R can't be combined with swap, which forwards the Deployment's ports itself")
+			os.Exit(2)
+		}
+		if err := remote.EnsureTools(); err != nil {
+			log.Print(err)
+			os.Exit(2)
+		}
+		swap, err := remote.PlanSwap(name, *swapPort)
+		if err != nil {
+			log.Print(err)
+			os.Exit(3)
+		}
+		swap.Image = *innerImage
+		host := "localhost"
+		if h, _, err := net.SplitHostPort(*target); err == nil && h != "" {
+			host = h
+		}
+		opts := proxyOptions{
+			pod:      swap.Pod,
+			grpcPort: swap.GRPCPort,
+			setup: []clusterChange{func() (func() error, error) {
+				log.Printf("Swapping out deploy/%s for pod/%s...", name, swap.Pod)
+				return swap.Start()
+			}},
+		}
+		for _, p := range swap.Ports {
+			opts.reverseForwards = append(opts.reverseForwards, localproxy.ReverseForward{
+				Port:  p,
+				Local: net.JoinHostPort(host, fmt.Sprint(p)),
+			})
+		}
+		runProxy(*forwards, opts)
+	},
+}
+
+func init() {
+	swapPort = SwapCmd.Flags().Int("http-port", 0, "The Deployment's port to send to --target; by default its first")
+	RootCmd.AddCommand(SwapCmd)
+}
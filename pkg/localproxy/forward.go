@@ -0,0 +1,206 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package localproxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/evankanderson/periscope/pkg/periscope"
+)
+
+// Forward is a local port whose connections are relayed to an address in
+// the cluster, like `ssh -L`.
+type Forward struct {
+	// The local address to listen on.
+	Listen string
+	// The host:port to connect to from the inner proxy.
+	Remote string
+}
+
+// ParseForward parses a forward in the `ssh -L` form of
+// [bind_address:]port:host:hostport. The bind address defaults to localhost.
+// IPv6 addresses go in brackets, as in [::1]:8080:[fd00::1]:80.
+func ParseForward(spec string) (Forward, error) {
+	parts := splitSpec(spec)
+	bind := "localhost"
+	switch len(parts) {
+	case 3:
+	case 4:
+		bind, parts = parts[0], parts[1:]
+	default:
+		return Forward{}, fmt.Errorf("Invalid forward %q, expected [bind_address:]port:host:hostport", spec)
+	}
+	for _, port := range []string{parts[0], parts[2]} {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return Forward{}, fmt.Errorf("Invalid port %q in forward %q", port, spec)
+		}
+	}
+	return Forward{
+		Listen: net.JoinHostPort(bind, parts[0]),
+		Remote: net.JoinHostPort(parts[1], parts[2]),
+	}, nil
+}
+
+// ReverseForward is a port in the cluster whose connections are relayed to a
+// local address, like `ssh -R`.
+type ReverseForward struct {
+	// The TCP port to listen on in the inner proxy's pod.
+	Port int
+	// The local host:port to connect to.
+	Local string
+}
+
+// ParseReverseForward parses a reverse forward in the `ssh -R` form of
+// port:host:hostport, with an IPv6 host in brackets.
+func ParseReverseForward(spec string) (ReverseForward, error) {
+	parts := splitSpec(spec)
+	if len(parts) != 3 {
+		return ReverseForward{}, fmt.Errorf("Invalid reverse forward %q, expected port:host:hostport", spec)
+	}
+	port, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return ReverseForward{}, fmt.Errorf("Invalid port %q in reverse forward %q", parts[0], spec)
+	}
+	if _, err := strconv.ParseUint(parts[2], 10, 16); err != nil {
+		return ReverseForward{}, fmt.Errorf("Invalid port %q in reverse forward %q", parts[2], spec)
+	}
+	return ReverseForward{
+		Port:  int(port),
+		Local: net.JoinHostPort(parts[1], parts[2]),
+	}, nil
+}
+
+// splitSpec splits a forward at the colons which aren't within brackets,
+// then drops the brackets around each part.
+func splitSpec(spec string) []string {
+	var parts []string
+	bracketed := false
+	start := 0
+	for i, c := range spec {
+		switch c {
+		case '[':
+			bracketed = true
+		case ']':
+			bracketed = false
+		case ':':
+			if !bracketed {
+				parts = append(parts, spec[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, spec[start:])
+	for i, p := range parts {
+		if strings.HasPrefix(p, "[") && strings.HasSuffix(p, "]") {
+			parts[i] = p[1 : len(p)-1]
+		}
+	}
+	return parts
+}
+
+// startForwards listens for each of forwards, returning a function which
+// stops listening.
+func startForwards(client periscope.PeriscopeClient, forwards []Forward) (func(), error) {
+	var listeners []net.Listener
+	stop := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+	for _, f := range forwards {
+		l, err := net.Listen("tcp", f.Listen)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		listeners = append(listeners, l)
+		log.Printf("Forwarding %q to %q", f.Listen, f.Remote)
+		go acceptForward(client, l, f.Remote)
+	}
+	return stop, nil
+}
+
+func acceptForward(client periscope.PeriscopeClient, l net.Listener, remote string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			tunnel, err := periscope.DialTunnel(context.Background(), client, remote)
+			if err != nil {
+				log.Printf("Failed to forward to %q: %s", remote, err)
+				conn.Close()
+				return
+			}
+			periscope.Join(conn, tunnel)
+		}()
+	}
+}
+
+// startReverseForwards asks the inner proxy to listen for each of forwards,
+// returning a function which stops listening.
+func startReverseForwards(client periscope.PeriscopeClient, forwards []ReverseForward) (func(), error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	for _, f := range forwards {
+		stream, err := client.Listen(ctx, &periscope.ListenRequest{Port: int32(f.Port)})
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		ack, err := stream.Recv()
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("Unable to listen on cluster port %d: %w", f.Port, err)
+		}
+		log.Printf("Forwarding cluster %q to %q", ack.Address, f.Local)
+		go acceptReverseForward(ctx, client, stream, f.Local)
+	}
+	return cancel, nil
+}
+
+func acceptReverseForward(ctx context.Context, client periscope.PeriscopeClient, stream periscope.Periscope_ListenClient, local string) {
+	for {
+		in, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Stopped forwarding to %q: %s", local, err)
+			}
+			return
+		}
+		go func() {
+			tunnel, err := periscope.AcceptTunnel(ctx, client, in.Id)
+			if err != nil {
+				log.Printf("Failed to accept %d: %s", in.Id, err)
+				return
+			}
+			conn, err := net.Dial("tcp", local)
+			if err != nil {
+				log.Printf("Failed to connect to %q: %s", local, err)
+				tunnel.Close()
+				return
+			}
+			log.Printf("LOCAL TCP %d: %s to %s", in.Id, in.Address, local)
+			periscope.Join(conn, tunnel)
+		}()
+	}
+}
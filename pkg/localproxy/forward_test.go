@@ -0,0 +1,80 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package localproxy
+
+import "testing"
+
+func TestParseForward(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Forward
+		wantErr bool
+	}{
+		{spec: "5432:postgres.db:5432", want: Forward{Listen: "localhost:5432", Remote: "postgres.db:5432"}},
+		{spec: "0.0.0.0:8080:web:80", want: Forward{Listen: "0.0.0.0:8080", Remote: "web:80"}},
+		{spec: "6379:10.0.0.1:6379", want: Forward{Listen: "localhost:6379", Remote: "10.0.0.1:6379"}},
+		{spec: "[::1]:8080:svc:80", want: Forward{Listen: "[::1]:8080", Remote: "svc:80"}},
+		{spec: "8080:[fd00::1]:80", want: Forward{Listen: "localhost:8080", Remote: "[fd00::1]:80"}},
+		{spec: "[::]:53:[fd00::a]:53", want: Forward{Listen: "[::]:53", Remote: "[fd00::a]:53"}},
+		{spec: "5432", wantErr: true},
+		{spec: "::1:8080:svc:80", wantErr: true},
+		{spec: "[::1:8080:svc:80", wantErr: true},
+		{spec: "5432:postgres.db", wantErr: true},
+		{spec: "a:b:c:d:e", wantErr: true},
+		{spec: "pg:postgres.db:5432", wantErr: true},
+		{spec: "5432:postgres.db:pg", wantErr: true},
+		{spec: "70000:postgres.db:5432", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseForward(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseForward(%q) error = %v, want error %t", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseForward(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestParseReverseForward(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    ReverseForward
+		wantErr bool
+	}{
+		{spec: "5432:localhost:5432", want: ReverseForward{Port: 5432, Local: "localhost:5432"}},
+		{spec: "8080:127.0.0.1:3000", want: ReverseForward{Port: 8080, Local: "127.0.0.1:3000"}},
+		{spec: "5432:[::1]:5432", want: ReverseForward{Port: 5432, Local: "[::1]:5432"}},
+		{spec: "8080:3000", wantErr: true},
+		{spec: "5432:::1:5432", wantErr: true},
+		{spec: "0.0.0.0:8080:localhost:3000", wantErr: true},
+		{spec: "http:localhost:3000", wantErr: true},
+		{spec: "8080:localhost:http", wantErr: true},
+		{spec: "8080:localhost:99999", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseReverseForward(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseReverseForward(%q) error = %v, want error %t", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseReverseForward(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
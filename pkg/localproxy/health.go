@@ -0,0 +1,88 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package localproxy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/evankanderson/periscope/pkg/periscope"
+)
+
+// healthInterval is how often the local target's health is checked.
+const healthInterval = 5 * time.Second
+
+// watchHealth checks the health of target until session ends, reporting
+// each change to the inner proxy. If path is set, target must answer a GET
+// for it with a status below 500; otherwise it need only accept connections.
+func watchHealth(session *periscope.Mux, target string, path string) {
+	// Not http.DefaultClient, which may send the check through periscope
+	// itself via $http_proxy.
+	client := &http.Client{Timeout: healthInterval, Transport: &http.Transport{}}
+	check := func() error {
+		if target == "" {
+			return errors.New("No target (-t) set")
+		}
+		if path == "" {
+			conn, err := net.DialTimeout("tcp", target, healthInterval)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}
+		resp, err := client.Get("http://" + target + path)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("%s returned %s", path, resp.Status)
+		}
+		return nil
+	}
+
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+	reported := false
+	healthy := false
+	for {
+		err := check()
+		if !reported || healthy != (err == nil) {
+			reported = true
+			healthy = err == nil
+			reason := ""
+			if healthy {
+				log.Printf("Target %q is healthy", target)
+			} else {
+				reason = err.Error()
+				log.Printf("Target %q is unhealthy: %s", target, reason)
+			}
+			if err := session.ReportHealth(healthy, reason); err != nil {
+				return
+			}
+		}
+		select {
+		case <-session.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -24,36 +24,207 @@ import (
 	"net"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/elazarl/goproxy"
 	"github.com/evankanderson/periscope/pkg/periscope"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
-func StartLocalProxy(port int, localTarget string, server string) error {
-	conn, err := grpc.Dial(server, grpc.WithInsecure())
+// Config describes what a local proxy serves.
+type Config struct {
+	// The local port for the HTTP proxy.
+	Port int
+	// If set, the local address to proxy requests from the cluster back to.
+	Target string
+	// If set, the path on Target to GET to check its health. Otherwise,
+	// Target is healthy if it accepts connections.
+	HealthPath string
+	// The address of the inner proxy's GRPC service.
+	Server string
+	// If set, the name to attach to the inner proxy with, so that requests
+	// out of the cluster for other developers' sessions aren't sent here.
+	Session string
+	// If set, only requests out of the cluster matching one of these come
+	// here; the inner proxy sends the rest to its fallback.
+	Rules []*periscope.Rule
+	// If set, the URL (or host:port) in the cluster of the fallback for
+	// this session, rather than the inner proxy's.
+	Fallback string
+	// If set, requests out of the cluster (matching Rules) are still
+	// answered by the inner proxy's fallback, and copies sent here.
+	Mirror bool
+	// If set (along with Mirror), the local target's answers to the copies
+	// are compared with the fallback's.
+	Shadow *Shadow
+
+	// Local ports forwarded into the cluster.
+	Forwards []Forward
+	// Ports in the cluster forwarded back to local addresses.
+	ReverseForwards []ReverseForward
+	// Local UDP ports forwarded into the cluster.
+	UDPForwards []Forward
+
+	// If set, called with the local proxy's address once it is listening.
+	Ready func(addr string)
+}
+
+// StartLocalProxy connects to the inner proxy and serves the local proxy
+// until the connection ends.
+func StartLocalProxy(cfg Config) error {
+	conn, err := grpc.Dial(cfg.Server, grpc.WithInsecure())
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
 	client := periscope.NewPeriscopeClient(conn)
 
+	caps, err := hello(client, cfg)
+	if err != nil {
+		return err
+	}
+	// Replace any rules left from an earlier run.
+	if caps[periscope.CapRules] {
+		rules := &periscope.Rules{Session: cfg.Session, Rules: cfg.Rules, Mirror: cfg.Mirror, Shadow: cfg.Shadow != nil, Fallback: cfg.Fallback}
+		if err := setRules(client, rules); err != nil {
+			return err
+		}
+	}
+	// Requests in both directions are multiplexed over a single Session.
+	ctx := metadata.AppendToOutgoingContext(context.Background(), periscope.SessionMetadata, cfg.Session)
+	stream, err := client.Session(ctx)
+	if err != nil {
+		return err
+	}
+	session := periscope.NewMux(stream, true, reverse(client, cfg.Target, cfg.Shadow))
+	// The inner proxy can send requests elsewhere while Target is down.
+	if caps[periscope.CapHealth] {
+		go watchHealth(session, cfg.Target, cfg.HealthPath)
+	}
+
+	stopForwards, err := startForwards(client, cfg.Forwards)
+	if err != nil {
+		return err
+	}
+	defer stopForwards()
+	stopReverseForwards, err := startReverseForwards(client, cfg.ReverseForwards)
+	if err != nil {
+		return err
+	}
+	defer stopReverseForwards()
+	stopUDPForwards, err := startUDPForwards(client, cfg.UDPForwards)
+	if err != nil {
+		return err
+	}
+	defer stopUDPForwards()
+
 	proxy := goproxy.NewProxyHttpServer()
 	// proxy.Verbose = true
-	proxy.OnRequest().DoFunc(forward(client))
-	listenAddr := fmt.Sprintf("localhost:%d", port)
-	log.Printf("Listening on %q, forwarding to %q. Incoming will connect to %q", listenAddr, server, localTarget)
+	proxy.OnRequest().DoFunc(forward(session))
+	// CONNECT (e.g. for https:// URLs) opens a raw tunnel from the inner
+	// proxy to the requested host:port.
+	proxy.ConnectDial = func(network string, addr string) (net.Conn, error) {
+		if network != "tcp" {
+			return nil, fmt.Errorf("Unsupported protocol %q", network)
+		}
+		log.Printf("CONNECT: %s", addr)
+		return periscope.DialTunnel(context.Background(), client, addr)
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", cfg.Port))
+	if err != nil {
+		return err
+	}
+	listenAddr := listener.Addr().String()
+	log.Printf("Listening on %q, forwarding to %q. Incoming will connect to %q", listenAddr, cfg.Server, cfg.Target)
+	if cfg.Session != "" {
+		log.Printf("Incoming requests are for session %q", cfg.Session)
+	}
+	for _, rule := range cfg.Rules {
+		log.Printf("Incoming requests matching %s", FormatRule(rule))
+	}
+	if cfg.Mirror {
+		log.Print("Incoming requests are copies; the cluster's fallback answers the originals")
+	}
+	if cfg.Fallback != "" {
+		log.Printf("The cluster's fallback is %q", cfg.Fallback)
+	}
 	httpServer := &http.Server{
-		Addr:    listenAddr,
-		Handler: proxy,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodConnect:
+				proxy.ServeHTTP(w, r)
+			case r.ProtoMajor == 2:
+				forwardH2(session, w, r)
+			case r.URL.IsAbs() && periscope.IsUpgrade(r.Header):
+				upgrade(client, w, r)
+			default:
+				// goproxy copies response bodies without flushing, which
+				// would hold back streamed responses.
+				proxy.ServeHTTP(periscope.FlushingResponseWriter{ResponseWriter: w}, r)
+			}
+		}),
 	}
+	// Accept cleartext HTTP/2 (h2c) with prior knowledge, as gRPC uses.
+	httpServer.Handler = periscope.H2CHandler(httpServer.Handler)
 
-	go httpServer.ListenAndServe()
+	go httpServer.Serve(listener)
 	defer httpServer.Shutdown(context.Background())
-	return startReverse(client, localTarget)
+	if cfg.Ready != nil {
+		cfg.Ready(listenAddr)
+	}
+	return session.Run()
+}
+
+// sameRelease is how to get an inner proxy which can work with this build.
+const sameRelease = "periscope and its inner proxy must come from the same release: run periscope --setup, which replaces pod/periscope-remote-proxy if it runs another release, or use the periscope release the pod came with"
+
+// hello checks that the inner proxy can work with this build, and supports
+// everything cfg asks for, returning the capabilities both support.
+func hello(client periscope.PeriscopeClient, cfg Config) (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	peer, err := client.Hello(ctx, periscope.NewHandshake())
+	if status.Code(err) == codes.Unimplemented {
+		return nil, fmt.Errorf("The inner proxy predates periscope %s; %s", periscope.BuildVersion(), sameRelease)
+	}
+	if status.Code(err) == codes.FailedPrecondition {
+		return nil, fmt.Errorf("%s; %s", status.Convert(err).Message(), sameRelease)
+	}
+	if err != nil {
+		return nil, err
+	}
+	caps, err := periscope.Negotiate(peer)
+	if err != nil {
+		return nil, fmt.Errorf("%s; %s", err, sameRelease)
+	}
+	log.Printf("Connected to periscope %s (protocol %d) with %s", peer.Version, peer.Protocol, periscope.FormatCapabilities(caps))
+
+	required := []struct {
+		used bool
+		cap  string
+		flag string
+	}{
+		{len(cfg.Forwards) > 0, periscope.CapTunnel, "-L"},
+		{len(cfg.ReverseForwards) > 0, periscope.CapListen, "-R"},
+		{len(cfg.UDPForwards) > 0, periscope.CapDatagrams, "-U"},
+		{cfg.Session != "", periscope.CapSessions, "--session"},
+		{len(cfg.Rules) > 0, periscope.CapRules, "--rule"},
+		{cfg.Mirror, periscope.CapMirror, "--mirror"},
+		{cfg.Fallback != "", periscope.CapFallback, "--fallback"},
+		{cfg.Shadow != nil, periscope.CapShadow, "--shadow"},
+	}
+	for _, r := range required {
+		if r.used && !caps[r.cap] {
+			return nil, fmt.Errorf("The inner proxy (periscope %s) doesn't support %s; %s", peer.Version, r.flag, sameRelease)
+		}
+	}
+	return caps, nil
 }
 
-func forward(client periscope.PeriscopeClient) func(*http.Request, *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+func forward(session *periscope.Mux) func(*http.Request, *goproxy.ProxyCtx) (*http.Request, *http.Response) {
 	return func(r *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
 		localError := func(message string, err error) (*http.Request, *http.Response) {
 			return r, &http.Response{
@@ -63,88 +234,191 @@ func forward(client periscope.PeriscopeClient) func(*http.Request, *goproxy.Prox
 			}
 		}
 
-		send, err := periscope.HttpToReq(*r)
-		if err != nil {
-			return localError("Failed encode", err)
-		}
-		out, err := client.In(context.Background(), send)
+		resp, err := roundTrip(session, r)
 		if err != nil {
 			return localError("Failed request", err)
 		}
-		resp, err := periscope.RespToHttp(out)
-		if err != nil {
-			return localError("Failed decode", err)
-		}
 		return r, resp
 	}
 }
 
-func startReverse(client periscope.PeriscopeClient, localTarget string) error {
-	localDial := func(ctx context.Context, network, addr string) (net.Conn, error) {
-		if network != "tcp" {
-			return nil, fmt.Errorf("Unsupported protocol %q", network)
+// forwardH2 proxies an HTTP/2 request, which goproxy doesn't handle: it
+// arrives addressed by :authority rather than an absolute URL, and may have
+// trailers (e.g. gRPC's grpc-status) to relay.
+func forwardH2(session *periscope.Mux, w http.ResponseWriter, r *http.Request) {
+	r.URL.Scheme = "http"
+	r.URL.Host = r.Host
+	r.RequestURI = r.URL.String()
+	resp, err := roundTrip(session, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(periscope.FlushingResponseWriter{ResponseWriter: w}, resp.Body)
+	periscope.WriteTrailers(w, resp.Trailer)
+}
+
+// roundTrip sends r into the cluster, returning the response while its body
+// is still streaming back. The response's Trailer is filled in once the body
+// has been read.
+func roundTrip(session *periscope.Mux, r *http.Request) (*http.Response, error) {
+	stream, err := session.Open(periscope.HttpToReq(r))
+	if err != nil {
+		return nil, err
+	}
+	// If the caller goes away, so does the request in the cluster.
+	stream.Watch(r.Context())
+	go func() {
+		if err := stream.SendBody(r.Body, func() http.Header { return r.Trailer }); err != nil {
+			log.Printf("Failed to stream request body: %s", err)
 		}
-		return net.Dial("tcp", localTarget)
+	}()
+
+	out, err := stream.Response()
+	if err != nil {
+		stream.Cancel()
+		return nil, err
 	}
-	httpClient := http.Client{
-		Transport: &http.Transport{
-			DialContext: localDial,
-		},
+	resp, err := periscope.RespToHttp(out)
+	if err != nil {
+		stream.Cancel()
+		return nil, err
 	}
+	resp.Trailer = make(http.Header)
+	resp.Body = periscope.NewBodyReader(stream.Recv, resp.Trailer, stream.Cancel)
+	return resp, nil
+}
 
-	stream, err := client.Out(context.Background())
+// upgrade relays a request which switches protocols (e.g. to WebSockets)
+// over a raw tunnel, as the connection stops being HTTP afterwards.
+func upgrade(client periscope.PeriscopeClient, w http.ResponseWriter, r *http.Request) {
+	address := r.URL.Host
+	if r.URL.Port() == "" {
+		address = net.JoinHostPort(r.URL.Hostname(), "80")
+	}
+	log.Printf("UPGRADE: %s", r.URL)
+	remote, err := periscope.DialTunnel(r.Context(), client, address)
 	if err != nil {
-		return err
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
 	}
-	if err := stream.Send(&periscope.ProxyResponse{
-		Id:      -1,
-		Status:  100,
-		Reason:  "Started",
-		Headers: map[string]string{"Preflight": "true"},
-		Body:    []byte{},
-	}); err != nil {
-		return err
+	local, buffered, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		remote.Close()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	for {
-		in, err := stream.Recv()
-		if err != nil {
-			return err
-		}
-		go localRequest(in, httpClient, stream)
+	r.Header.Del("Proxy-Connection")
+	r.Header.Del("Proxy-Authorization")
+	if err := r.Write(remote); err != nil {
+		log.Printf("Failed to send upgrade request: %s", err)
+		local.Close()
+		remote.Close()
+		return
 	}
-	// return nil
+	periscope.Join(periscope.WithBuffered(local, buffered.Reader), remote)
 }
 
-func localRequest(in *periscope.ProxyRequest, client http.Client, stream periscope.Periscope_OutClient) {
-	errorResponse := func(message string, err error) {
-		stream.Send(&periscope.ProxyResponse{
-			Id:     in.Id,
-			Status: 500,
-			Reason: fmt.Sprintf("%s: %s", message, err),
-		})
+// reverse returns the handler for requests out of the cluster, which it
+// proxies to localTarget.
+func reverse(client periscope.PeriscopeClient, localTarget string, shadow *Shadow) func(*periscope.Stream) {
+	localDial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if network != "tcp" {
+			return nil, fmt.Errorf("Unsupported protocol %q", network)
+		}
+		return (&net.Dialer{}).DialContext(ctx, "tcp", localTarget)
+	}
+	// Not through $http_proxy, which may well be periscope itself.
+	httpClient := http.Client{
+		Transport: periscope.NewTransport(localDial, nil),
 	}
+	return func(stream *periscope.Stream) {
+		if in := stream.Request(); in.Upgrade {
+			// The connection follows over Accept, not the stream.
+			stream.Close()
+			localUpgrade(in, client, localTarget)
+			return
+		}
+		localRequest(stream, httpClient, shadow)
+	}
+}
 
-	req, err := periscope.ReqToHttp(in)
+// localRequest proxies a request out of the cluster to the local target. If
+// shadow is set, answers to mirrored requests are compared with it.
+func localRequest(stream *periscope.Stream, client http.Client, shadow *Shadow) {
+	req, err := periscope.ReqToHttp(stream.Request())
 	if err != nil {
-		errorResponse("Failed encode", err)
+		stream.RespondError("Failed encode", err)
 		return
 	}
+	// Cancelled if the caller in the cluster goes away.
+	req = req.WithContext(stream.Context())
+	req.Body = periscope.NewBodyReader(stream.Recv, nil, nil)
 	req.URL.Scheme = "http"
-	log.Printf("LOCAL: %s", req.URL)
+	log.Printf("LOCAL %d: %s", stream.ID(), req.URL)
 
 	resp, err := client.Do(req)
 	if err != nil {
-		errorResponse("Failed request", err)
+		log.Printf("LOCAL %d: %s", stream.ID(), err)
+		stream.RespondError("Failed request", err)
 		return
 	}
-	out, err := periscope.HttpToResp(*resp)
-	if err != nil {
-		errorResponse("Failed decode", err)
+	defer resp.Body.Close()
+	out := periscope.HttpToResp(resp)
+	log.Printf("LOCAL resp: %s", out.Reason)
+	if err := stream.Respond(out); err != nil {
+		log.Printf("Failed to stream response: %s", err)
 		return
 	}
-	out.Id = in.Id
-	log.Printf("LOCAL resp: %s", out.Reason)
-	if err := stream.Send(out); err != nil {
+	var body io.Reader = resp.Body
+	var kept *cappedBuffer
+	if shadow != nil && stream.Request().Answer != nil {
+		kept = &cappedBuffer{}
+		body = io.TeeReader(resp.Body, kept)
+	}
+	if err := stream.SendBody(body, func() http.Header { return resp.Trailer }); err != nil {
 		log.Printf("Failed to stream response: %s", err)
+		return
+	}
+	if kept != nil {
+		shadow.compare(stream.ID(), stream.Request(), resp, kept)
+	}
+}
+
+// localUpgrade picks up an upgraded request from the inner proxy and relays
+// it to localTarget as raw bytes.
+func localUpgrade(in *periscope.ProxyRequest, client periscope.PeriscopeClient, localTarget string) {
+	remote, err := periscope.AcceptTunnel(context.Background(), client, in.Id)
+	if err != nil {
+		log.Printf("Failed to accept upgrade %d: %s", in.Id, err)
+		return
+	}
+	req, err := periscope.ReqToHttp(in)
+	if err != nil {
+		log.Printf("Failed to decode upgrade %d: %s", in.Id, err)
+		remote.Close()
+		return
+	}
+	// Upgrades carry no body; the rest of the connection follows raw.
+	req.ContentLength = 0
+	log.Printf("LOCAL UPGRADE: %s", req.URL)
+	local, err := net.Dial("tcp", localTarget)
+	if err != nil {
+		log.Printf("Failed to connect to %q: %s", localTarget, err)
+		io.WriteString(remote, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+		remote.Close()
+		return
+	}
+	if err := req.Write(local); err != nil {
+		log.Printf("Failed to send upgrade request: %s", err)
+		local.Close()
+		remote.Close()
+		return
 	}
+	periscope.Join(local, remote)
 }
@@ -0,0 +1,138 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package localproxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evankanderson/periscope/pkg/periscope"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ParseRule parses a rule picking requests out of the cluster to send here,
+// in the form method=GET,path=/api/v2/*,header=X-Debug:me,percent=10. Every
+// part is optional, and header may be repeated.
+func ParseRule(spec string) (*periscope.Rule, error) {
+	rule := &periscope.Rule{}
+	for _, part := range strings.Split(spec, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("Invalid rule %q, expected key=value parts separated by ','", spec)
+		}
+		switch key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]); key {
+		case "method":
+			rule.Method = strings.ToUpper(value)
+		case "path":
+			rule.Path = value
+		case "header":
+			h := strings.SplitN(value, ":", 2)
+			if len(h) != 2 {
+				return nil, fmt.Errorf("Invalid header %q in rule %q, expected name:value", value, spec)
+			}
+			rule.Headers = append(rule.Headers, &periscope.Header{
+				Name:  http.CanonicalHeaderKey(strings.TrimSpace(h[0])),
+				Value: strings.TrimSpace(h[1]),
+			})
+		case "percent":
+			percent, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+			if err != nil || percent < 1 || percent > 100 {
+				return nil, fmt.Errorf("Invalid percent %q in rule %q, expected 1 to 100", value, spec)
+			}
+			rule.Percent = int32(percent)
+		default:
+			return nil, fmt.Errorf("Unknown key %q in rule %q, expected method, path, header or percent", key, spec)
+		}
+	}
+	return rule, nil
+}
+
+// FormatRule returns rule in the form ParseRule accepts.
+func FormatRule(rule *periscope.Rule) string {
+	var parts []string
+	if rule.Method != "" {
+		parts = append(parts, "method="+rule.Method)
+	}
+	if rule.Path != "" {
+		parts = append(parts, "path="+rule.Path)
+	}
+	for _, h := range rule.Headers {
+		parts = append(parts, fmt.Sprintf("header=%s:%s", h.Name, h.Value))
+	}
+	if rule.Percent > 0 {
+		parts = append(parts, fmt.Sprintf("percent=%d", rule.Percent))
+	}
+	return strings.Join(parts, ",")
+}
+
+// SetRules replaces the rules of a session on the inner proxy at server,
+// e.g. while periscope runs. With no rules, the session gets every request
+// for it.
+func SetRules(server string, rules *periscope.Rules) error {
+	conn, err := grpc.Dial(server, grpc.WithInsecure())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	client := periscope.NewPeriscopeClient(conn)
+	// Older inner proxies would take the requests rather than mirror them,
+	// or ignore the fallback.
+	if rules.Mirror || rules.Fallback != "" {
+		if _, err := hello(client, Config{Mirror: rules.Mirror, Fallback: rules.Fallback}); err != nil {
+			return err
+		}
+	}
+	return setRules(client, rules)
+}
+
+// GetRules returns the rules of session on the inner proxy at server.
+func GetRules(server string, session string) (*periscope.Rules, error) {
+	conn, err := grpc.Dial(server, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := periscope.NewPeriscopeClient(conn).GetRules(ctx, &periscope.Rules{Session: session})
+	if err != nil {
+		return nil, rulesError("get", err)
+	}
+	return out, nil
+}
+
+func setRules(client periscope.PeriscopeClient, rules *periscope.Rules) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := client.SetRules(ctx, rules); err != nil {
+		return rulesError("set", err)
+	}
+	return nil
+}
+
+func rulesError(action string, err error) error {
+	if status.Code(err) == codes.Unimplemented {
+		return fmt.Errorf("The inner proxy doesn't support rules; %s", sameRelease)
+	}
+	return fmt.Errorf("Unable to %s rules: %s", action, status.Convert(err).Message())
+}
@@ -0,0 +1,84 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package localproxy
+
+import (
+	"testing"
+
+	"github.com/evankanderson/periscope/pkg/periscope"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    *periscope.Rule
+		wantErr bool
+	}{
+		{spec: "method=get", want: &periscope.Rule{Method: "GET"}},
+		{spec: "path=/api/v2/*", want: &periscope.Rule{Path: "/api/v2/*"}},
+		{spec: "header=x-debug:me", want: &periscope.Rule{Headers: []*periscope.Header{{Name: "X-Debug", Value: "me"}}}},
+		{spec: "header=X-A:1, header=X-B: b:c", want: &periscope.Rule{Headers: []*periscope.Header{{Name: "X-A", Value: "1"}, {Name: "X-B", Value: "b:c"}}}},
+		{spec: "percent=10%", want: &periscope.Rule{Percent: 10}},
+		{spec: "method=POST,path=/orders,percent=100", want: &periscope.Rule{Method: "POST", Path: "/orders", Percent: 100}},
+		{spec: "", wantErr: true},
+		{spec: "method", wantErr: true},
+		{spec: "header=X-Debug", wantErr: true},
+		{spec: "percent=0", wantErr: true},
+		{spec: "percent=101", wantErr: true},
+		{spec: "percent=half", wantErr: true},
+		{spec: "host=example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRule(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRule(%q) error = %v, want error %t", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !proto.Equal(got, tt.want) {
+			t.Errorf("ParseRule(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestFormatRule(t *testing.T) {
+	tests := []struct {
+		rule *periscope.Rule
+		want string
+	}{
+		{rule: &periscope.Rule{}, want: ""},
+		{rule: &periscope.Rule{Method: "GET", Percent: 10}, want: "method=GET,percent=10"},
+		{
+			rule: &periscope.Rule{Method: "PUT", Path: "/api/*", Headers: []*periscope.Header{{Name: "X-A", Value: "1"}, {Name: "X-B", Value: "2"}}, Percent: 50},
+			want: "method=PUT,path=/api/*,header=X-A:1,header=X-B:2,percent=50",
+		},
+	}
+	for _, tt := range tests {
+		got := FormatRule(tt.rule)
+		if got != tt.want {
+			t.Errorf("FormatRule(%v) = %q, want %q", tt.rule, got, tt.want)
+		}
+		if got == "" {
+			continue
+		}
+		// What FormatRule prints, ParseRule reads back.
+		back, err := ParseRule(got)
+		if err != nil || !proto.Equal(back, tt.rule) {
+			t.Errorf("ParseRule(%q) = %v (%v), want %v", got, back, err, tt.rule)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package localproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evankanderson/periscope/pkg/periscope"
+)
+
+// shadowBodyLimit is the largest local response body compared, matching
+// what the inner proxy records of the fallback's.
+const shadowBodyLimit = 1 << 20
+
+// ShadowConfig describes how copies of requests out of the cluster are
+// compared with the fallback's answers.
+type ShadowConfig struct {
+	// The file each comparison is appended to, as a line of JSON.
+	Report string
+	// The response headers to compare.
+	Headers []string
+	// Paths in JSON bodies to leave out of the comparison, as dot-separated
+	// keys and array indexes, e.g. "meta.requestId" or "items.*.createdAt".
+	// "*" matches any one key or index.
+	Ignore []string
+}
+
+// A ShadowDifference is one way the local target's response differed from
+// the fallback's.
+type ShadowDifference struct {
+	// "status", "header <name>", "body", or "body.<path>" for part of a
+	// JSON body.
+	Field   string      `json:"field"`
+	Cluster interface{} `json:"cluster,omitempty"`
+	Local   interface{} `json:"local,omitempty"`
+	// "cluster" or "local" if the field is missing from that response.
+	Missing string `json:"missing,omitempty"`
+}
+
+// A ShadowResult is a line of the report.
+type ShadowResult struct {
+	Time        time.Time          `json:"time"`
+	Method      string             `json:"method"`
+	Target      string             `json:"target"`
+	Match       bool               `json:"match"`
+	Differences []ShadowDifference `json:"differences,omitempty"`
+	// Why part of the responses wasn't compared, if it wasn't.
+	Note string `json:"note,omitempty"`
+}
+
+// A Shadow compares the local target's answers to copies of requests with
+// the fallback's, and reports the differences.
+type Shadow struct {
+	cfg    ShadowConfig
+	ignore [][]string
+
+	lock     sync.Mutex
+	report   *os.File
+	compared int
+	matched  int
+}
+
+// OpenShadow opens the report of cfg, for Config.Shadow. The caller closes
+// it once the local proxy stops.
+func OpenShadow(cfg ShadowConfig) (*Shadow, error) {
+	report, err := os.OpenFile(cfg.Report, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open shadow report: %w", err)
+	}
+	s := &Shadow{cfg: cfg, report: report}
+	for _, p := range cfg.Ignore {
+		s.ignore = append(s.ignore, strings.Split(strings.TrimPrefix(p, "body."), "."))
+	}
+	return s, nil
+}
+
+// Close logs a summary of the comparisons and closes the report.
+func (s *Shadow) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	log.Printf("SHADOW: %d of %d compared requests matched; see %q", s.matched, s.compared, s.cfg.Report)
+	return s.report.Close()
+}
+
+// compare compares the local target's response to in, with the body local
+// (or as much as was kept), with the fallback's answer to it.
+func (s *Shadow) compare(id uint32, in *periscope.ProxyRequest, resp *http.Response, local *cappedBuffer) {
+	answer := in.Answer
+	result := ShadowResult{Time: time.Now(), Method: in.Verb, Target: in.Target}
+	if status := int32(resp.StatusCode); status != answer.Response.Status {
+		result.Differences = append(result.Differences, ShadowDifference{Field: "status", Cluster: answer.Response.Status, Local: status})
+	}
+	clusterHeader := periscope.HeadersFromProto(answer.Response.Headers)
+	for _, name := range s.cfg.Headers {
+		c, l := clusterHeader.Values(name), resp.Header.Values(name)
+		if reflect.DeepEqual(c, l) {
+			continue
+		}
+		d := ShadowDifference{Field: "header " + http.CanonicalHeaderKey(name)}
+		switch {
+		case len(c) == 0:
+			d.Missing, d.Local = "cluster", strings.Join(l, ", ")
+		case len(l) == 0:
+			d.Missing, d.Cluster = "local", strings.Join(c, ", ")
+		default:
+			d.Cluster, d.Local = strings.Join(c, ", "), strings.Join(l, ", ")
+		}
+		result.Differences = append(result.Differences, d)
+	}
+	if answer.Truncated || local.overflow {
+		result.Note = fmt.Sprintf("Bodies over %d bytes aren't compared", shadowBodyLimit)
+	} else {
+		result.Differences = append(result.Differences, s.compareBodies(answer.Body, local.Bytes())...)
+	}
+	result.Match = len(result.Differences) == 0
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.compared++
+	if result.Match {
+		s.matched++
+	}
+	if err := json.NewEncoder(s.report).Encode(result); err != nil {
+		log.Printf("SHADOW: unable to write report: %s", err)
+	}
+	summary := "match"
+	if !result.Match {
+		var fields []string
+		for _, d := range result.Differences {
+			fields = append(fields, d.Field)
+		}
+		summary = fmt.Sprintf("differs in %s", strings.Join(fields, ", "))
+	}
+	log.Printf("SHADOW %d: %s %s: %s; %d of %d matched", id, in.Verb, in.Target, summary, s.matched, s.compared)
+}
+
+// compareBodies compares JSON bodies field by field, and others whole.
+func (s *Shadow) compareBodies(cluster []byte, local []byte) []ShadowDifference {
+	c, cerr := decodeJSON(cluster)
+	l, lerr := decodeJSON(local)
+	if cerr != nil || lerr != nil {
+		if bytes.Equal(cluster, local) {
+			return nil
+		}
+		return []ShadowDifference{{
+			Field:   "body",
+			Cluster: fmt.Sprintf("%d bytes", len(cluster)),
+			Local:   fmt.Sprintf("%d bytes", len(local)),
+		}}
+	}
+	var diffs []ShadowDifference
+	s.diffJSON(nil, c, l, &diffs)
+	return diffs
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	if d.More() {
+		return nil, fmt.Errorf("Expected a single JSON value")
+	}
+	return v, nil
+}
+
+func (s *Shadow) diffJSON(path []string, c interface{}, l interface{}, diffs *[]ShadowDifference) {
+	if s.ignored(path) {
+		return
+	}
+	field := strings.Join(append([]string{"body"}, path...), ".")
+	switch c := c.(type) {
+	case map[string]interface{}:
+		if l, ok := l.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(c)+len(l))
+			for k := range c {
+				keys = append(keys, k)
+			}
+			for k := range l {
+				if _, ok := c[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				s.diffMember(append(path, k), c, l, k, diffs)
+			}
+			return
+		}
+	case []interface{}:
+		if l, ok := l.([]interface{}); ok {
+			for i := 0; i < len(c) || i < len(l); i++ {
+				p := append(path, strconv.Itoa(i))
+				switch {
+				case i >= len(l):
+					if !s.ignored(p) {
+						*diffs = append(*diffs, ShadowDifference{Field: field + "." + p[len(p)-1], Cluster: c[i], Missing: "local"})
+					}
+				case i >= len(c):
+					if !s.ignored(p) {
+						*diffs = append(*diffs, ShadowDifference{Field: field + "." + p[len(p)-1], Local: l[i], Missing: "cluster"})
+					}
+				default:
+					s.diffJSON(p, c[i], l[i], diffs)
+				}
+			}
+			return
+		}
+	default:
+		if reflect.DeepEqual(c, l) {
+			return
+		}
+	}
+	*diffs = append(*diffs, ShadowDifference{Field: field, Cluster: c, Local: l})
+}
+
+func (s *Shadow) diffMember(path []string, c map[string]interface{}, l map[string]interface{}, key string, diffs *[]ShadowDifference) {
+	cv, cok := c[key]
+	lv, lok := l[key]
+	if cok && lok {
+		s.diffJSON(path, cv, lv, diffs)
+		return
+	}
+	if s.ignored(path) {
+		return
+	}
+	d := ShadowDifference{Field: strings.Join(append([]string{"body"}, path...), "."), Cluster: cv, Local: lv}
+	if !cok {
+		d.Missing = "cluster"
+	} else {
+		d.Missing = "local"
+	}
+	*diffs = append(*diffs, d)
+}
+
+// ignored returns whether path, or a path it is under, is ignored.
+func (s *Shadow) ignored(path []string) bool {
+	for _, pattern := range s.ignore {
+		if len(pattern) > len(path) {
+			continue
+		}
+		match := true
+		for i, p := range pattern {
+			if p != "*" && p != path[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// cappedBuffer keeps up to shadowBodyLimit bytes written to it, and notes
+// whether more were.
+type cappedBuffer struct {
+	bytes.Buffer
+	overflow bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if !b.overflow {
+		if b.Len()+len(p) > shadowBodyLimit {
+			b.overflow = true
+			b.Reset()
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,212 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package localproxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/evankanderson/periscope/pkg/periscope"
+)
+
+func newTestShadow(t *testing.T, cfg ShadowConfig) *Shadow {
+	t.Helper()
+	cfg.Report = filepath.Join(t.TempDir(), "shadow.jsonl")
+	s, err := OpenShadow(cfg)
+	if err != nil {
+		t.Fatalf("OpenShadow: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestCompareBodies(t *testing.T) {
+	tests := []struct {
+		name           string
+		ignore         []string
+		cluster, local string
+		want           []ShadowDifference
+	}{
+		{name: "same text", cluster: "hello", local: "hello"},
+		{name: "other text", cluster: "hello", local: "goodbye", want: []ShadowDifference{{Field: "body", Cluster: "5 bytes", Local: "7 bytes"}}},
+		{name: "JSON and text", cluster: `{"a":1}`, local: "a=1", want: []ShadowDifference{{Field: "body", Cluster: "7 bytes", Local: "3 bytes"}}},
+		{name: "several JSON values", cluster: `{"a":1} {"a":2}`, local: `{"a":1} {"a":3}`, want: []ShadowDifference{{Field: "body", Cluster: "15 bytes", Local: "15 bytes"}}},
+		{name: "both empty", cluster: "", local: ""},
+		{name: "same JSON, reordered", cluster: `{"a":1,"b":[true,null]}`, local: "{\n  \"b\": [true, null],\n  \"a\": 1\n}"},
+		{name: "changed field", cluster: `{"a":1,"b":"x"}`, local: `{"a":2,"b":"x"}`, want: []ShadowDifference{{Field: "body.a", Cluster: json.Number("1"), Local: json.Number("2")}}},
+		{
+			name:    "missing fields",
+			cluster: `{"a":1,"b":2}`, local: `{"b":2,"c":3}`,
+			want: []ShadowDifference{
+				{Field: "body.a", Cluster: json.Number("1"), Missing: "local"},
+				{Field: "body.c", Local: json.Number("3"), Missing: "cluster"},
+			},
+		},
+		{name: "changed type", cluster: `{"a":[1]}`, local: `{"a":{"0":1}}`, want: []ShadowDifference{{Field: "body.a", Cluster: []interface{}{json.Number("1")}, Local: map[string]interface{}{"0": json.Number("1")}}}},
+		{name: "array element", cluster: `[1,2,3]`, local: `[1,5,3]`, want: []ShadowDifference{{Field: "body.1", Cluster: json.Number("2"), Local: json.Number("5")}}},
+		{name: "shorter array", cluster: `{"items":[1,2]}`, local: `{"items":[1]}`, want: []ShadowDifference{{Field: "body.items.1", Cluster: json.Number("2"), Missing: "local"}}},
+		{name: "longer array", cluster: `[1]`, local: `[1,"x"]`, want: []ShadowDifference{{Field: "body.1", Local: "x", Missing: "cluster"}}},
+		{name: "ignored field", ignore: []string{"meta.requestId"}, cluster: `{"meta":{"requestId":"a","v":1}}`, local: `{"meta":{"requestId":"b","v":1}}`},
+		{name: "ignored with body prefix", ignore: []string{"body.id"}, cluster: `{"id":1}`, local: `{"id":2}`},
+		{name: "ignored missing field", ignore: []string{"id"}, cluster: `{"id":1}`, local: `{}`},
+		{name: "ignored subtree", ignore: []string{"meta"}, cluster: `{"meta":{"a":1}}`, local: `{"meta":[2]}`},
+		{
+			name:    "ignored in every element",
+			ignore:  []string{"items.*.createdAt"},
+			cluster: `{"items":[{"id":1,"createdAt":"x"},{"id":2,"createdAt":"y"}]}`,
+			local:   `{"items":[{"id":1,"createdAt":"z"},{"id":3,"createdAt":"w"}]}`,
+			want:    []ShadowDifference{{Field: "body.items.1.id", Cluster: json.Number("2"), Local: json.Number("3")}},
+		},
+		{name: "ignored extra element", ignore: []string{"*.1"}, cluster: `[[1]]`, local: `[[1,2]]`},
+		{name: "ignore doesn't match prefix", ignore: []string{"meta.requestId.x"}, cluster: `{"meta":{"requestId":"a"}}`, local: `{"meta":{"requestId":"b"}}`, want: []ShadowDifference{{Field: "body.meta.requestId", Cluster: "a", Local: "b"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestShadow(t, ShadowConfig{Ignore: tt.ignore})
+			got := s.compareBodies([]byte(tt.cluster), []byte(tt.local))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("compareBodies(%s, %s) = %#v, want %#v", tt.cluster, tt.local, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name          string
+		answer        *periscope.Answer
+		status        int
+		header        http.Header
+		body          string
+		wantMatch     bool
+		wantFields    []string
+		wantTruncated bool
+	}{
+		{
+			name:      "match",
+			answer:    &periscope.Answer{Response: &periscope.ProxyResponse{Status: 200, Headers: []*periscope.Header{{Name: "Content-Type", Value: "text/plain"}}}, Body: []byte("ok")},
+			status:    200,
+			header:    http.Header{"Content-Type": {"text/plain"}},
+			body:      "ok",
+			wantMatch: true,
+		},
+		{
+			name:       "status, header and body",
+			answer:     &periscope.Answer{Response: &periscope.ProxyResponse{Status: 200, Headers: []*periscope.Header{{Name: "Content-Type", Value: "application/json"}}}, Body: []byte(`{"a":1}`)},
+			status:     500,
+			header:     http.Header{"Content-Type": {"text/plain"}},
+			body:       "oops",
+			wantFields: []string{"status", "header Content-Type", "body"},
+		},
+		{
+			name:       "missing header",
+			answer:     &periscope.Answer{Response: &periscope.ProxyResponse{Status: 204}},
+			status:     204,
+			header:     http.Header{"Content-Type": {"text/plain"}},
+			wantFields: []string{"header Content-Type"},
+		},
+		{
+			name:          "cluster body truncated",
+			answer:        &periscope.Answer{Response: &periscope.ProxyResponse{Status: 200}, Truncated: true},
+			status:        200,
+			body:          "different",
+			wantMatch:     true,
+			wantTruncated: true,
+		},
+		{
+			name:          "local body over the limit",
+			answer:        &periscope.Answer{Response: &periscope.ProxyResponse{Status: 200}, Body: []byte("small")},
+			status:        200,
+			body:          strings.Repeat("x", shadowBodyLimit+1),
+			wantMatch:     true,
+			wantTruncated: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestShadow(t, ShadowConfig{Headers: []string{"content-type"}})
+			local := &cappedBuffer{}
+			local.Write([]byte(tt.body))
+			header := tt.header
+			if header == nil {
+				header = http.Header{}
+			}
+			s.compare(1, &periscope.ProxyRequest{Verb: "GET", Target: "/x", Answer: tt.answer}, &http.Response{StatusCode: tt.status, Header: header}, local)
+
+			f, err := os.Open(s.cfg.Report)
+			if err != nil {
+				t.Fatalf("Opening the report: %v", err)
+			}
+			defer f.Close()
+			lines := bufio.NewScanner(f)
+			if !lines.Scan() {
+				t.Fatalf("The report is empty: %v", lines.Err())
+			}
+			var got ShadowResult
+			if err := json.Unmarshal(lines.Bytes(), &got); err != nil {
+				t.Fatalf("Decoding the report: %v", err)
+			}
+			var fields []string
+			for _, d := range got.Differences {
+				fields = append(fields, d.Field)
+			}
+			if got.Match != tt.wantMatch || !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("Got match=%t, differences in %v; want match=%t, differences in %v", got.Match, fields, tt.wantMatch, tt.wantFields)
+			}
+			if (got.Note != "") != tt.wantTruncated {
+				t.Errorf("Got note %q, want one: %t", got.Note, tt.wantTruncated)
+			}
+			if got.Method != "GET" || got.Target != "/x" {
+				t.Errorf("Reported %s %s, want GET /x", got.Method, got.Target)
+			}
+		})
+	}
+}
+
+func TestCappedBuffer(t *testing.T) {
+	tests := []struct {
+		name         string
+		writes       []int
+		wantLen      int
+		wantOverflow bool
+	}{
+		{name: "empty", wantLen: 0},
+		{name: "under", writes: []int{10, 20}, wantLen: 30},
+		{name: "exactly the limit", writes: []int{shadowBodyLimit - 1, 1}, wantLen: shadowBodyLimit},
+		{name: "over in one write", writes: []int{shadowBodyLimit + 1}, wantOverflow: true},
+		{name: "over, then more", writes: []int{shadowBodyLimit, 1, 5}, wantOverflow: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &cappedBuffer{}
+			for _, n := range tt.writes {
+				if written, err := b.Write(make([]byte, n)); written != n || err != nil {
+					t.Fatalf("Write(%d bytes) = %d, %v; want every byte taken", n, written, err)
+				}
+			}
+			if b.Len() != tt.wantLen || b.overflow != tt.wantOverflow {
+				t.Errorf("Kept %d bytes (overflow=%t), want %d (overflow=%t)", b.Len(), b.overflow, tt.wantLen, tt.wantOverflow)
+			}
+		})
+	}
+}
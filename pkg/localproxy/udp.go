@@ -0,0 +1,172 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package localproxy
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/evankanderson/periscope/pkg/periscope"
+)
+
+// udpSession is a local UDP sender whose datagrams are relayed to the
+// cluster.
+type udpSession struct {
+	// The local socket the sender talks to.
+	conn       *net.UDPConn
+	peer       *net.UDPAddr
+	lastActive time.Time
+}
+
+// udpRelay multiplexes the sessions of all UDP forwards over one Datagrams
+// call.
+type udpRelay struct {
+	stream periscope.Periscope_DatagramsClient
+	writer *periscope.Writer
+
+	lock        sync.Mutex
+	nextSession int64
+	sessions    map[int64]*udpSession
+	// Session ids by local socket and sender address.
+	bySource map[string]int64
+}
+
+// startUDPForwards listens for each of forwards as UDP, returning a function
+// which stops listening.
+func startUDPForwards(client periscope.PeriscopeClient, forwards []Forward) (func(), error) {
+	if len(forwards) == 0 {
+		return func() {}, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.Datagrams(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	r := &udpRelay{
+		stream:   stream,
+		writer:   periscope.NewWriter(stream),
+		sessions: make(map[int64]*udpSession),
+		bySource: make(map[string]int64),
+	}
+	var conns []*net.UDPConn
+	stop := func() {
+		cancel()
+		for _, c := range conns {
+			c.Close()
+		}
+	}
+	for _, f := range forwards {
+		addr, err := net.ResolveUDPAddr("udp", f.Listen)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		conn, err := net.ListenUDP("udp", addr)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		conns = append(conns, conn)
+		log.Printf("Forwarding UDP %q to %q", f.Listen, f.Remote)
+		go r.serve(conn, f.Remote)
+	}
+	go r.replies()
+	go func() {
+		ticker := time.NewTicker(periscope.UDPIdleTimeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				r.expire(now.Add(-periscope.UDPIdleTimeout))
+			}
+		}
+	}()
+	return stop, nil
+}
+
+// serve relays datagrams received on conn to remote, until conn is closed.
+func (r *udpRelay) serve(conn *net.UDPConn, remote string) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, peer, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		session := r.session(conn, peer)
+		if err := r.writer.Send(&periscope.Datagram{Session: session, Address: remote, Data: data}); err != nil {
+			log.Printf("Failed to forward UDP to %q: %s", remote, err)
+			return
+		}
+	}
+}
+
+// replies relays datagrams from the cluster back to the local senders.
+func (r *udpRelay) replies() {
+	for {
+		in, err := r.stream.Recv()
+		if err != nil {
+			return
+		}
+		r.lock.Lock()
+		sess := r.sessions[in.Session]
+		if sess != nil {
+			sess.lastActive = time.Now()
+		}
+		r.lock.Unlock()
+		if sess == nil {
+			continue
+		}
+		sess.conn.WriteToUDP(in.Data, sess.peer)
+	}
+}
+
+// session returns the session for peer sending to conn, starting a new one
+// if needed.
+func (r *udpRelay) session(conn *net.UDPConn, peer *net.UDPAddr) int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	source := conn.LocalAddr().String() + ">" + peer.String()
+	if id, ok := r.bySource[source]; ok {
+		r.sessions[id].lastActive = time.Now()
+		return id
+	}
+	r.nextSession++
+	id := r.nextSession
+	r.sessions[id] = &udpSession{conn: conn, peer: peer, lastActive: time.Now()}
+	r.bySource[source] = id
+	return id
+}
+
+// expire forgets sessions last active before cutoff.
+func (r *udpRelay) expire(cutoff time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for source, id := range r.bySource {
+		if r.sessions[id].lastActive.Before(cutoff) {
+			delete(r.sessions, id)
+			delete(r.bySource, source)
+		}
+	}
+}
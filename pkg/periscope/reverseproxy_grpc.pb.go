@@ -18,16 +18,46 @@ const _ = grpc.SupportPackageIsVersion7
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type PeriscopeClient interface {
-	// Proxy a request _into_ the cluster.
+	// Exchange versions and capabilities.
 	//
-	// Proxies an HTTP request into the cluster.
-	In(ctx context.Context, in *ProxyRequest, opts ...grpc.CallOption) (*ProxyResponse, error)
-	// Proxy a request _out_ of the cluster.
+	// Called by the outside proxy when it connects, before anything else.
+	Hello(ctx context.Context, in *Handshake, opts ...grpc.CallOption) (*Handshake, error)
+	// Proxy HTTP requests _into_ and _out_ of the cluster.
 	//
-	// This works by having the outside proxy connect to the inner proxy,
-	// and then sending one ProxyResponse for each ProxyRequest streamed
-	// out from the cluster.
-	Out(ctx context.Context, opts ...grpc.CallOption) (Periscope_OutClient, error)
+	// The outside proxy holds a single Session open while it runs. Requests
+	// in either direction are multiplexed over it as streams of Frames, so
+	// that losing the inner proxy is one event, however many requests were
+	// in flight.
+	Session(ctx context.Context, opts ...grpc.CallOption) (Periscope_SessionClient, error)
+	// Open a raw TCP connection _into_ the cluster.
+	//
+	// Used for CONNECT requests (e.g. https:// URLs) and protocol upgrades
+	// (e.g. WebSockets), after which the connection is no longer HTTP.
+	// Closing the sending side half-closes the connection.
+	Tunnel(ctx context.Context, opts ...grpc.CallOption) (Periscope_TunnelClient, error)
+	// Accept a raw connection _out_ of the cluster.
+	//
+	// The inner proxy announces connections which need to be relayed raw
+	// (e.g. an upgraded request) on Session, and the outside proxy picks each
+	// of them up with a separate Accept call.
+	Accept(ctx context.Context, opts ...grpc.CallOption) (Periscope_AcceptClient, error)
+	// Listen on an extra TCP port in the cluster.
+	//
+	// The first TunnelOpen carries the address listened on. Each following
+	// one announces a connection, which the outside proxy picks up with
+	// Accept. The port is closed when the call ends.
+	Listen(ctx context.Context, in *ListenRequest, opts ...grpc.CallOption) (Periscope_ListenClient, error)
+	// Replace the rules of a session, returning them.
+	//
+	// Rules outlive the session, so that they apply again if it reconnects.
+	SetRules(ctx context.Context, in *Rules, opts ...grpc.CallOption) (*Rules, error)
+	// Return the rules of the session named in the request.
+	GetRules(ctx context.Context, in *Rules, opts ...grpc.CallOption) (*Rules, error)
+	// Relay UDP datagrams _into_ the cluster, and replies back out.
+	//
+	// Sessions which see no traffic for a while are expired by each side;
+	// a datagram for an expired session starts a new one.
+	Datagrams(ctx context.Context, opts ...grpc.CallOption) (Periscope_DatagramsClient, error)
 }
 
 type periscopeClient struct {
@@ -38,40 +68,183 @@ func NewPeriscopeClient(cc grpc.ClientConnInterface) PeriscopeClient {
 	return &periscopeClient{cc}
 }
 
-func (c *periscopeClient) In(ctx context.Context, in *ProxyRequest, opts ...grpc.CallOption) (*ProxyResponse, error) {
-	out := new(ProxyResponse)
-	err := c.cc.Invoke(ctx, "/periscope.Periscope/In", in, out, opts...)
+func (c *periscopeClient) Hello(ctx context.Context, in *Handshake, opts ...grpc.CallOption) (*Handshake, error) {
+	out := new(Handshake)
+	err := c.cc.Invoke(ctx, "/periscope.Periscope/Hello", in, out, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *periscopeClient) Out(ctx context.Context, opts ...grpc.CallOption) (Periscope_OutClient, error) {
-	stream, err := c.cc.NewStream(ctx, &Periscope_ServiceDesc.Streams[0], "/periscope.Periscope/Out", opts...)
+func (c *periscopeClient) Session(ctx context.Context, opts ...grpc.CallOption) (Periscope_SessionClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Periscope_ServiceDesc.Streams[0], "/periscope.Periscope/Session", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &periscopeSessionClient{stream}
+	return x, nil
+}
+
+type Periscope_SessionClient interface {
+	Send(*Frame) error
+	Recv() (*Frame, error)
+	grpc.ClientStream
+}
+
+type periscopeSessionClient struct {
+	grpc.ClientStream
+}
+
+func (x *periscopeSessionClient) Send(m *Frame) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *periscopeSessionClient) Recv() (*Frame, error) {
+	m := new(Frame)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *periscopeClient) Tunnel(ctx context.Context, opts ...grpc.CallOption) (Periscope_TunnelClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Periscope_ServiceDesc.Streams[1], "/periscope.Periscope/Tunnel", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &periscopeTunnelClient{stream}
+	return x, nil
+}
+
+type Periscope_TunnelClient interface {
+	Send(*TunnelData) error
+	Recv() (*TunnelData, error)
+	grpc.ClientStream
+}
+
+type periscopeTunnelClient struct {
+	grpc.ClientStream
+}
+
+func (x *periscopeTunnelClient) Send(m *TunnelData) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *periscopeTunnelClient) Recv() (*TunnelData, error) {
+	m := new(TunnelData)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *periscopeClient) Accept(ctx context.Context, opts ...grpc.CallOption) (Periscope_AcceptClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Periscope_ServiceDesc.Streams[2], "/periscope.Periscope/Accept", opts...)
 	if err != nil {
 		return nil, err
 	}
-	x := &periscopeOutClient{stream}
+	x := &periscopeAcceptClient{stream}
 	return x, nil
 }
 
-type Periscope_OutClient interface {
-	Send(*ProxyResponse) error
-	Recv() (*ProxyRequest, error)
+type Periscope_AcceptClient interface {
+	Send(*TunnelData) error
+	Recv() (*TunnelData, error)
 	grpc.ClientStream
 }
 
-type periscopeOutClient struct {
+type periscopeAcceptClient struct {
 	grpc.ClientStream
 }
 
-func (x *periscopeOutClient) Send(m *ProxyResponse) error {
+func (x *periscopeAcceptClient) Send(m *TunnelData) error {
 	return x.ClientStream.SendMsg(m)
 }
 
-func (x *periscopeOutClient) Recv() (*ProxyRequest, error) {
-	m := new(ProxyRequest)
+func (x *periscopeAcceptClient) Recv() (*TunnelData, error) {
+	m := new(TunnelData)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *periscopeClient) Listen(ctx context.Context, in *ListenRequest, opts ...grpc.CallOption) (Periscope_ListenClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Periscope_ServiceDesc.Streams[3], "/periscope.Periscope/Listen", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &periscopeListenClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Periscope_ListenClient interface {
+	Recv() (*TunnelOpen, error)
+	grpc.ClientStream
+}
+
+type periscopeListenClient struct {
+	grpc.ClientStream
+}
+
+func (x *periscopeListenClient) Recv() (*TunnelOpen, error) {
+	m := new(TunnelOpen)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *periscopeClient) SetRules(ctx context.Context, in *Rules, opts ...grpc.CallOption) (*Rules, error) {
+	out := new(Rules)
+	err := c.cc.Invoke(ctx, "/periscope.Periscope/SetRules", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *periscopeClient) GetRules(ctx context.Context, in *Rules, opts ...grpc.CallOption) (*Rules, error) {
+	out := new(Rules)
+	err := c.cc.Invoke(ctx, "/periscope.Periscope/GetRules", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *periscopeClient) Datagrams(ctx context.Context, opts ...grpc.CallOption) (Periscope_DatagramsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Periscope_ServiceDesc.Streams[4], "/periscope.Periscope/Datagrams", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &periscopeDatagramsClient{stream}
+	return x, nil
+}
+
+type Periscope_DatagramsClient interface {
+	Send(*Datagram) error
+	Recv() (*Datagram, error)
+	grpc.ClientStream
+}
+
+type periscopeDatagramsClient struct {
+	grpc.ClientStream
+}
+
+func (x *periscopeDatagramsClient) Send(m *Datagram) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *periscopeDatagramsClient) Recv() (*Datagram, error) {
+	m := new(Datagram)
 	if err := x.ClientStream.RecvMsg(m); err != nil {
 		return nil, err
 	}
@@ -82,16 +255,46 @@ func (x *periscopeOutClient) Recv() (*ProxyRequest, error) {
 // All implementations must embed UnimplementedPeriscopeServer
 // for forward compatibility
 type PeriscopeServer interface {
-	// Proxy a request _into_ the cluster.
+	// Exchange versions and capabilities.
+	//
+	// Called by the outside proxy when it connects, before anything else.
+	Hello(context.Context, *Handshake) (*Handshake, error)
+	// Proxy HTTP requests _into_ and _out_ of the cluster.
+	//
+	// The outside proxy holds a single Session open while it runs. Requests
+	// in either direction are multiplexed over it as streams of Frames, so
+	// that losing the inner proxy is one event, however many requests were
+	// in flight.
+	Session(Periscope_SessionServer) error
+	// Open a raw TCP connection _into_ the cluster.
+	//
+	// Used for CONNECT requests (e.g. https:// URLs) and protocol upgrades
+	// (e.g. WebSockets), after which the connection is no longer HTTP.
+	// Closing the sending side half-closes the connection.
+	Tunnel(Periscope_TunnelServer) error
+	// Accept a raw connection _out_ of the cluster.
 	//
-	// Proxies an HTTP request into the cluster.
-	In(context.Context, *ProxyRequest) (*ProxyResponse, error)
-	// Proxy a request _out_ of the cluster.
+	// The inner proxy announces connections which need to be relayed raw
+	// (e.g. an upgraded request) on Session, and the outside proxy picks each
+	// of them up with a separate Accept call.
+	Accept(Periscope_AcceptServer) error
+	// Listen on an extra TCP port in the cluster.
 	//
-	// This works by having the outside proxy connect to the inner proxy,
-	// and then sending one ProxyResponse for each ProxyRequest streamed
-	// out from the cluster.
-	Out(Periscope_OutServer) error
+	// The first TunnelOpen carries the address listened on. Each following
+	// one announces a connection, which the outside proxy picks up with
+	// Accept. The port is closed when the call ends.
+	Listen(*ListenRequest, Periscope_ListenServer) error
+	// Replace the rules of a session, returning them.
+	//
+	// Rules outlive the session, so that they apply again if it reconnects.
+	SetRules(context.Context, *Rules) (*Rules, error)
+	// Return the rules of the session named in the request.
+	GetRules(context.Context, *Rules) (*Rules, error)
+	// Relay UDP datagrams _into_ the cluster, and replies back out.
+	//
+	// Sessions which see no traffic for a while are expired by each side;
+	// a datagram for an expired session starts a new one.
+	Datagrams(Periscope_DatagramsServer) error
 	mustEmbedUnimplementedPeriscopeServer()
 }
 
@@ -99,11 +302,29 @@ type PeriscopeServer interface {
 type UnimplementedPeriscopeServer struct {
 }
 
-func (UnimplementedPeriscopeServer) In(context.Context, *ProxyRequest) (*ProxyResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method In not implemented")
+func (UnimplementedPeriscopeServer) Hello(context.Context, *Handshake) (*Handshake, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Hello not implemented")
+}
+func (UnimplementedPeriscopeServer) Session(Periscope_SessionServer) error {
+	return status.Errorf(codes.Unimplemented, "method Session not implemented")
+}
+func (UnimplementedPeriscopeServer) Tunnel(Periscope_TunnelServer) error {
+	return status.Errorf(codes.Unimplemented, "method Tunnel not implemented")
+}
+func (UnimplementedPeriscopeServer) Accept(Periscope_AcceptServer) error {
+	return status.Errorf(codes.Unimplemented, "method Accept not implemented")
+}
+func (UnimplementedPeriscopeServer) Listen(*ListenRequest, Periscope_ListenServer) error {
+	return status.Errorf(codes.Unimplemented, "method Listen not implemented")
 }
-func (UnimplementedPeriscopeServer) Out(Periscope_OutServer) error {
-	return status.Errorf(codes.Unimplemented, "method Out not implemented")
+func (UnimplementedPeriscopeServer) SetRules(context.Context, *Rules) (*Rules, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetRules not implemented")
+}
+func (UnimplementedPeriscopeServer) GetRules(context.Context, *Rules) (*Rules, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRules not implemented")
+}
+func (UnimplementedPeriscopeServer) Datagrams(Periscope_DatagramsServer) error {
+	return status.Errorf(codes.Unimplemented, "method Datagrams not implemented")
 }
 func (UnimplementedPeriscopeServer) mustEmbedUnimplementedPeriscopeServer() {}
 
@@ -118,44 +339,179 @@ func RegisterPeriscopeServer(s grpc.ServiceRegistrar, srv PeriscopeServer) {
 	s.RegisterService(&Periscope_ServiceDesc, srv)
 }
 
-func _Periscope_In_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(ProxyRequest)
+func _Periscope_Hello_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Handshake)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PeriscopeServer).Hello(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/periscope.Periscope/Hello",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PeriscopeServer).Hello(ctx, req.(*Handshake))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Periscope_Session_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PeriscopeServer).Session(&periscopeSessionServer{stream})
+}
+
+type Periscope_SessionServer interface {
+	Send(*Frame) error
+	Recv() (*Frame, error)
+	grpc.ServerStream
+}
+
+type periscopeSessionServer struct {
+	grpc.ServerStream
+}
+
+func (x *periscopeSessionServer) Send(m *Frame) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *periscopeSessionServer) Recv() (*Frame, error) {
+	m := new(Frame)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Periscope_Tunnel_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PeriscopeServer).Tunnel(&periscopeTunnelServer{stream})
+}
+
+type Periscope_TunnelServer interface {
+	Send(*TunnelData) error
+	Recv() (*TunnelData, error)
+	grpc.ServerStream
+}
+
+type periscopeTunnelServer struct {
+	grpc.ServerStream
+}
+
+func (x *periscopeTunnelServer) Send(m *TunnelData) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *periscopeTunnelServer) Recv() (*TunnelData, error) {
+	m := new(TunnelData)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Periscope_Accept_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PeriscopeServer).Accept(&periscopeAcceptServer{stream})
+}
+
+type Periscope_AcceptServer interface {
+	Send(*TunnelData) error
+	Recv() (*TunnelData, error)
+	grpc.ServerStream
+}
+
+type periscopeAcceptServer struct {
+	grpc.ServerStream
+}
+
+func (x *periscopeAcceptServer) Send(m *TunnelData) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *periscopeAcceptServer) Recv() (*TunnelData, error) {
+	m := new(TunnelData)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Periscope_Listen_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListenRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PeriscopeServer).Listen(m, &periscopeListenServer{stream})
+}
+
+type Periscope_ListenServer interface {
+	Send(*TunnelOpen) error
+	grpc.ServerStream
+}
+
+type periscopeListenServer struct {
+	grpc.ServerStream
+}
+
+func (x *periscopeListenServer) Send(m *TunnelOpen) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Periscope_SetRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Rules)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(PeriscopeServer).In(ctx, in)
+		return srv.(PeriscopeServer).SetRules(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: "/periscope.Periscope/In",
+		FullMethod: "/periscope.Periscope/SetRules",
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(PeriscopeServer).In(ctx, req.(*ProxyRequest))
+		return srv.(PeriscopeServer).SetRules(ctx, req.(*Rules))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _Periscope_Out_Handler(srv interface{}, stream grpc.ServerStream) error {
-	return srv.(PeriscopeServer).Out(&periscopeOutServer{stream})
+func _Periscope_GetRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Rules)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PeriscopeServer).GetRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/periscope.Periscope/GetRules",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PeriscopeServer).GetRules(ctx, req.(*Rules))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Periscope_Datagrams_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PeriscopeServer).Datagrams(&periscopeDatagramsServer{stream})
 }
 
-type Periscope_OutServer interface {
-	Send(*ProxyRequest) error
-	Recv() (*ProxyResponse, error)
+type Periscope_DatagramsServer interface {
+	Send(*Datagram) error
+	Recv() (*Datagram, error)
 	grpc.ServerStream
 }
 
-type periscopeOutServer struct {
+type periscopeDatagramsServer struct {
 	grpc.ServerStream
 }
 
-func (x *periscopeOutServer) Send(m *ProxyRequest) error {
+func (x *periscopeDatagramsServer) Send(m *Datagram) error {
 	return x.ServerStream.SendMsg(m)
 }
 
-func (x *periscopeOutServer) Recv() (*ProxyResponse, error) {
-	m := new(ProxyResponse)
+func (x *periscopeDatagramsServer) Recv() (*Datagram, error) {
+	m := new(Datagram)
 	if err := x.ServerStream.RecvMsg(m); err != nil {
 		return nil, err
 	}
@@ -170,14 +526,45 @@ var Periscope_ServiceDesc = grpc.ServiceDesc{
 	HandlerType: (*PeriscopeServer)(nil),
 	Methods: []grpc.MethodDesc{
 		{
-			MethodName: "In",
-			Handler:    _Periscope_In_Handler,
+			MethodName: "Hello",
+			Handler:    _Periscope_Hello_Handler,
+		},
+		{
+			MethodName: "SetRules",
+			Handler:    _Periscope_SetRules_Handler,
+		},
+		{
+			MethodName: "GetRules",
+			Handler:    _Periscope_GetRules_Handler,
 		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
-			StreamName:    "Out",
-			Handler:       _Periscope_Out_Handler,
+			StreamName:    "Session",
+			Handler:       _Periscope_Session_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Tunnel",
+			Handler:       _Periscope_Tunnel_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Accept",
+			Handler:       _Periscope_Accept_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Listen",
+			Handler:       _Periscope_Listen_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Datagrams",
+			Handler:       _Periscope_Datagrams_Handler,
 			ServerStreams: true,
 			ClientStreams: true,
 		},
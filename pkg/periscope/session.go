@@ -0,0 +1,431 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package periscope
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// InitialWindow is how many bytes of body either side of a Session may send
+// on a stream before the receiver grants more with a WindowUpdate.
+const InitialWindow = 256 * 1024
+
+// SessionMetadata is the gRPC metadata key naming a Session, so that several
+// developers can share an inner proxy.
+const SessionMetadata = "periscope-session"
+
+var errSessionClosed = errors.New("Session closed")
+
+// FrameStream is either end of a Session call.
+type FrameStream interface {
+	MessageStream
+	Recv() (*Frame, error)
+}
+
+// Mux multiplexes requests in both directions over a Session call.
+type Mux struct {
+	stream FrameStream
+	// Called in a new goroutine for each stream the peer opens.
+	handler func(*Stream)
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	// Sends frames for every stream, in order.
+	writer *Writer
+
+	lock    sync.Mutex
+	streams map[uint32]*Stream
+	// The id of the next stream opened by this side.
+	next uint32
+	// Set once the Session has ended.
+	err error
+	// The health of the peer's local target, as last reported.
+	health *Health
+}
+
+// NewMux multiplexes streams over a Session call. The outside proxy, which
+// makes the call, is the client. handler is called in a new goroutine for
+// each stream the peer opens, and should answer its Request.
+func NewMux(stream FrameStream, client bool, handler func(*Stream)) *Mux {
+	m := &Mux{
+		stream:  stream,
+		writer:  NewWriter(stream),
+		handler: handler,
+		streams: make(map[uint32]*Stream),
+		next:    2,
+		health:  &Health{Healthy: true},
+	}
+	if client {
+		m.next = 1
+	}
+	m.ctx, m.cancel = context.WithCancel(stream.Context())
+	return m
+}
+
+// Run receives frames until the Session ends, then fails every stream still
+// in flight. It returns nil if the peer closed the Session.
+func (m *Mux) Run() error {
+	err := m.receive()
+	failure := err
+	if err == io.EOF {
+		failure = errSessionClosed
+		err = nil
+	}
+	m.lock.Lock()
+	m.err = failure
+	streams := m.streams
+	m.streams = make(map[uint32]*Stream)
+	m.lock.Unlock()
+	for _, s := range streams {
+		s.fail(failure)
+	}
+	m.cancel()
+	return err
+}
+
+func (m *Mux) receive() error {
+	for {
+		in, err := m.stream.Recv()
+		if err != nil {
+			return err
+		}
+		s := m.lookup(in.Stream)
+		switch kind := in.Kind.(type) {
+		case *Frame_Request:
+			if s != nil || in.Stream == 0 || in.Stream%2 == m.next%2 {
+				return fmt.Errorf("Unexpected request on stream %d", in.Stream)
+			}
+			s = m.newStream(in.Stream, kind.Request)
+			go m.handler(s)
+		case *Frame_Response:
+			if s != nil {
+				select {
+				case s.response <- kind.Response:
+				default:
+				}
+			}
+		case *Frame_Chunk:
+			if s != nil {
+				s.receive(kind.Chunk)
+			}
+		case *Frame_Cancel:
+			if s != nil && m.forget(s.id) {
+				s.fail(context.Canceled)
+			}
+		case *Frame_Window:
+			if s != nil {
+				s.grant(int(kind.Window.Increment))
+			}
+		case *Frame_Health:
+			m.lock.Lock()
+			m.health = kind.Health
+			m.lock.Unlock()
+		}
+	}
+}
+
+// Context ends when the Session does.
+func (m *Mux) Context() context.Context {
+	return m.ctx
+}
+
+// Healthy returns whether the peer last reported its local target healthy,
+// and if not, why. Peers are healthy until they report otherwise.
+func (m *Mux) Healthy() (bool, string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.health.Healthy, m.health.Reason
+}
+
+// ReportHealth tells the peer whether this side's local target is healthy.
+func (m *Mux) ReportHealth(healthy bool, reason string) error {
+	return m.send(&Frame{Kind: &Frame_Health{Health: &Health{Healthy: healthy, Reason: reason}}})
+}
+
+// Open starts a stream carrying req. Its body should follow with SendBody,
+// and the answer be read with Response and Recv.
+func (m *Mux) Open(req *ProxyRequest) (*Stream, error) {
+	m.lock.Lock()
+	if m.err != nil {
+		m.lock.Unlock()
+		return nil, m.err
+	}
+	id := m.next
+	m.next += 2
+	m.lock.Unlock()
+	s := m.newStream(id, nil)
+	if err := m.send(&Frame{Stream: id, Kind: &Frame_Request{Request: req}}); err != nil {
+		m.forget(id)
+		s.fail(err)
+		return nil, err
+	}
+	return s, nil
+}
+
+func (m *Mux) newStream(id uint32, req *ProxyRequest) *Stream {
+	s := &Stream{
+		id:       id,
+		mux:      m,
+		request:  req,
+		response: make(chan *ProxyResponse, 1),
+		window:   InitialWindow,
+	}
+	s.cond = sync.NewCond(&s.lock)
+	s.ctx, s.cancel = context.WithCancel(m.ctx)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.err != nil {
+		s.err = m.err
+		s.cancel()
+		return s
+	}
+	m.streams[id] = s
+	return s
+}
+
+func (m *Mux) lookup(id uint32) *Stream {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.streams[id]
+}
+
+// forget drops the stream with id, returning whether it was still in flight.
+func (m *Mux) forget(id uint32) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	_, ok := m.streams[id]
+	delete(m.streams, id)
+	return ok
+}
+
+func (m *Mux) send(out *Frame) error {
+	return m.writer.Send(out)
+}
+
+// Stream is a single request and its response within a Session.
+type Stream struct {
+	id  uint32
+	mux *Mux
+	// The request, for streams opened by the peer.
+	request  *ProxyRequest
+	response chan *ProxyResponse
+	// Ends when the stream is finished or abandoned.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	lock sync.Mutex
+	// Signalled when body arrives, the window grows, or the stream fails.
+	cond *sync.Cond
+	// Body received but not yet read.
+	chunks []*BodyChunk
+	// How much more body this side may send.
+	window int
+	// How much body has been read since the last WindowUpdate.
+	unacked int
+	sentEnd bool
+	recvEnd bool
+	err     error
+}
+
+// ID identifies the stream within its Session, e.g. for logging.
+func (s *Stream) ID() uint32 {
+	return s.id
+}
+
+// Request returns the request of a stream opened by the peer.
+func (s *Stream) Request() *ProxyRequest {
+	return s.request
+}
+
+// Context ends when the stream is finished or abandoned by either side.
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// Response waits for the response to a stream opened by Open.
+func (s *Stream) Response() (*ProxyResponse, error) {
+	select {
+	case out := <-s.response:
+		return out, nil
+	case <-s.ctx.Done():
+		select {
+		case out := <-s.response:
+			return out, nil
+		default:
+		}
+		return nil, s.failure()
+	}
+}
+
+// Respond sends the response to a stream opened by the peer. Its body
+// should follow with SendBody.
+func (s *Stream) Respond(out *ProxyResponse) error {
+	return s.mux.send(&Frame{Stream: s.id, Kind: &Frame_Response{Response: out}})
+}
+
+// RespondError answers a stream opened by the peer with an empty 500
+// response, giving message and err as the reason.
+func (s *Stream) RespondError(message string, err error) error {
+	if err := s.Respond(&ProxyResponse{
+		Status: 500,
+		Reason: fmt.Sprintf("%s: %s", message, err),
+	}); err != nil {
+		return err
+	}
+	return s.SendBody(nil, nil)
+}
+
+// SendBody sends the body of this side's request or response, waiting for
+// the peer to grant more window as needed.
+func (s *Stream) SendBody(body io.Reader, trailer func() http.Header) error {
+	return SendBody(body, trailer, s.sendChunk)
+}
+
+func (s *Stream) sendChunk(c *BodyChunk) error {
+	n := len(c.Data)
+	s.lock.Lock()
+	for s.window < n && s.err == nil {
+		s.cond.Wait()
+	}
+	if s.err != nil {
+		defer s.lock.Unlock()
+		return s.err
+	}
+	s.window -= n
+	s.lock.Unlock()
+	if err := s.mux.send(&Frame{Stream: s.id, Kind: &Frame_Chunk{Chunk: c}}); err != nil {
+		return err
+	}
+	if c.End {
+		s.lock.Lock()
+		s.sentEnd = true
+		done := s.recvEnd
+		s.lock.Unlock()
+		if done {
+			s.finish()
+		}
+	}
+	return nil
+}
+
+// Recv returns the next chunk of the peer's body, for NewBodyReader.
+func (s *Stream) Recv() (*BodyChunk, error) {
+	s.lock.Lock()
+	for len(s.chunks) == 0 && !s.recvEnd && s.err == nil {
+		s.cond.Wait()
+	}
+	if len(s.chunks) == 0 {
+		defer s.lock.Unlock()
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, io.EOF
+	}
+	c := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	s.unacked += len(c.Data)
+	grant := 0
+	// Batch updates rather than sending one per chunk; there's no point
+	// once the whole body has arrived.
+	if s.unacked >= InitialWindow/4 && !s.recvEnd {
+		grant = s.unacked
+		s.unacked = 0
+	}
+	s.lock.Unlock()
+	if grant > 0 {
+		s.mux.send(&Frame{Stream: s.id, Kind: &Frame_Window{Window: &WindowUpdate{Increment: int32(grant)}}})
+	}
+	return c, nil
+}
+
+// Cancel abandons the stream, and tells the peer to do the same, unless it
+// has already finished.
+func (s *Stream) Cancel() {
+	if !s.mux.forget(s.id) {
+		return
+	}
+	s.fail(context.Canceled)
+	s.mux.send(&Frame{Stream: s.id, Kind: &Frame_Cancel{Cancel: &Cancel{}}})
+}
+
+// Close drops a stream which will carry nothing more, such as an upgrade,
+// without telling the peer.
+func (s *Stream) Close() {
+	s.finish()
+}
+
+// Watch cancels the stream if ctx ends first, e.g. because the caller of
+// its request went away.
+func (s *Stream) Watch(ctx context.Context) {
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Cancel()
+		case <-s.ctx.Done():
+		}
+	}()
+}
+
+func (s *Stream) receive(c *BodyChunk) {
+	s.lock.Lock()
+	s.chunks = append(s.chunks, c)
+	if c.End {
+		s.recvEnd = true
+	}
+	done := s.recvEnd && s.sentEnd
+	s.cond.Broadcast()
+	s.lock.Unlock()
+	if done {
+		s.finish()
+	}
+}
+
+func (s *Stream) grant(n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.window += n
+	s.cond.Broadcast()
+}
+
+func (s *Stream) fail(err error) {
+	s.lock.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.cond.Broadcast()
+	s.lock.Unlock()
+	s.cancel()
+}
+
+func (s *Stream) finish() {
+	s.mux.forget(s.id)
+	s.cancel()
+}
+
+func (s *Stream) failure() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	return s.ctx.Err()
+}
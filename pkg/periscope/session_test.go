@@ -0,0 +1,316 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package periscope
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// pipe is one end of an in-memory Session call.
+type pipe struct {
+	ctx context.Context
+	in  <-chan *Frame
+	out chan<- *Frame
+
+	lock   sync.Mutex
+	closed bool
+	// Bytes of body data sent.
+	sent int64
+}
+
+// newPipe returns both ends of an in-memory Session call, which ends with
+// ctx.
+func newPipe(ctx context.Context) (*pipe, *pipe) {
+	ab := make(chan *Frame, 1024)
+	ba := make(chan *Frame, 1024)
+	return &pipe{ctx: ctx, in: ba, out: ab}, &pipe{ctx: ctx, in: ab, out: ba}
+}
+
+func (p *pipe) SendMsg(m interface{}) error {
+	// As if sent over the wire.
+	f := proto.Clone(m.(*Frame)).(*Frame)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return io.ErrClosedPipe
+	}
+	if c := f.GetChunk(); c != nil {
+		atomic.AddInt64(&p.sent, int64(len(c.Data)))
+	}
+	select {
+	case p.out <- f:
+		return nil
+	case <-p.ctx.Done():
+		return p.ctx.Err()
+	}
+}
+
+func (p *pipe) Recv() (*Frame, error) {
+	select {
+	case f, ok := <-p.in:
+		if !ok {
+			return nil, io.EOF
+		}
+		return f, nil
+	case <-p.ctx.Done():
+		return nil, p.ctx.Err()
+	}
+}
+
+func (p *pipe) Context() context.Context {
+	return p.ctx
+}
+
+// CloseSend ends this side of the call, so the peer's Recv returns io.EOF.
+func (p *pipe) CloseSend() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.out)
+	}
+}
+
+func (p *pipe) bodySent() int {
+	return int(atomic.LoadInt64(&p.sent))
+}
+
+type session struct {
+	client, server         *Mux
+	clientPipe, serverPipe *pipe
+	// The result of the server's Run.
+	serverDone chan error
+}
+
+// newSession connects a client Mux to a server Mux whose streams are
+// answered by handler.
+func newSession(t *testing.T, handler func(*Stream)) *session {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s := &session{serverDone: make(chan error, 1)}
+	s.clientPipe, s.serverPipe = newPipe(ctx)
+	s.client = NewMux(s.clientPipe, true, func(*Stream) {})
+	s.server = NewMux(s.serverPipe, false, handler)
+	go s.client.Run()
+	go func() { s.serverDone <- s.server.Run() }()
+	return s
+}
+
+// eventually waits for cond to hold.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func receive(t *testing.T, what string, c <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-c:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %s", what)
+		return nil
+	}
+}
+
+func TestSessionRequestResponse(t *testing.T) {
+	s := newSession(t, func(st *Stream) {
+		body, err := io.ReadAll(NewBodyReader(st.Recv, nil, nil))
+		if err != nil {
+			st.RespondError("Reading body", err)
+			return
+		}
+		st.Respond(&ProxyResponse{Status: 200, Headers: []*Header{{Name: "X-Verb", Value: st.Request().Verb}}})
+		st.SendBody(bytes.NewReader(bytes.ToUpper(body)), nil)
+	})
+
+	st, err := s.client.Open(&ProxyRequest{Verb: "POST", Target: "/echo"})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := st.SendBody(bytes.NewReader([]byte("hello")), nil); err != nil {
+		t.Fatalf("SendBody: %v", err)
+	}
+	resp, err := st.Response()
+	if err != nil {
+		t.Fatalf("Response: %v", err)
+	}
+	if resp.Status != 200 || HeadersFromProto(resp.Headers).Get("X-Verb") != "POST" {
+		t.Errorf("Got response %v, want 200 with X-Verb: POST", resp)
+	}
+	body, err := io.ReadAll(NewBodyReader(st.Recv, nil, nil))
+	if err != nil || string(body) != "HELLO" {
+		t.Errorf("Got body %q (%v), want %q", body, err, "HELLO")
+	}
+}
+
+func TestSessionWindow(t *testing.T) {
+	release := make(chan struct{})
+	received := make(chan []byte, 1)
+	s := newSession(t, func(st *Stream) {
+		<-release
+		body, _ := io.ReadAll(NewBodyReader(st.Recv, nil, nil))
+		st.Respond(&ProxyResponse{Status: 200})
+		st.SendBody(nil, nil)
+		received <- body
+	})
+
+	body := make([]byte, 3*InitialWindow+100)
+	for i := range body {
+		body[i] = byte(i % 251)
+	}
+	st, err := s.client.Open(&ProxyRequest{Verb: "PUT"})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	sent := make(chan error, 1)
+	go func() { sent <- st.SendBody(bytes.NewReader(body), nil) }()
+
+	// The sender stops once the window is used up, while nothing is read.
+	eventually(t, "the window to fill", func() bool { return s.clientPipe.bodySent() >= InitialWindow })
+	time.Sleep(50 * time.Millisecond)
+	if got := s.clientPipe.bodySent(); got != InitialWindow {
+		t.Errorf("Sent %d bytes before anything was read, want %d", got, InitialWindow)
+	}
+	select {
+	case err := <-sent:
+		t.Fatalf("SendBody returned %v before the window was refilled", err)
+	default:
+	}
+
+	// Reading grants more window, until the whole body is through.
+	close(release)
+	if err := receive(t, "SendBody", sent); err != nil {
+		t.Errorf("SendBody: %v", err)
+	}
+	select {
+	case got := <-received:
+		if !bytes.Equal(got, body) {
+			t.Errorf("Received %d bytes, which differ from the %d sent", len(got), len(body))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the body")
+	}
+	if resp, err := st.Response(); err != nil || resp.Status != 200 {
+		t.Errorf("Got response %v (%v), want 200", resp, err)
+	}
+}
+
+func TestSessionPeerCancel(t *testing.T) {
+	handlerDone := make(chan error, 1)
+	s := newSession(t, func(st *Stream) {
+		st.Respond(&ProxyResponse{Status: 200})
+		// More than the window, so that this waits for the client.
+		err := st.SendBody(io.LimitReader(zeros{}, 4*InitialWindow), nil)
+		<-st.Context().Done()
+		handlerDone <- err
+	})
+
+	st, err := s.client.Open(&ProxyRequest{Verb: "GET"})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	st.SendBody(nil, nil)
+	if _, err := st.Response(); err != nil {
+		t.Fatalf("Response: %v", err)
+	}
+	if _, err := st.Recv(); err != nil {
+		t.Fatalf("Recv: %v", err)
+	}
+	st.Cancel()
+
+	if err := receive(t, "the handler to be cancelled", handlerDone); !errors.Is(err, context.Canceled) {
+		t.Errorf("Handler's SendBody returned %v, want %v", err, context.Canceled)
+	}
+	// What already arrived may still be read, but not the end of the body.
+	if _, err := io.ReadAll(NewBodyReader(st.Recv, nil, nil)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Reading the body after Cancel returned %v, want %v", err, context.Canceled)
+	}
+	// The Session carries on.
+	next, err := s.client.Open(&ProxyRequest{Verb: "GET"})
+	if err != nil {
+		t.Fatalf("Open after Cancel: %v", err)
+	}
+	next.Cancel()
+}
+
+func TestSessionEndsMidBody(t *testing.T) {
+	started := make(chan struct{})
+	handlerDone := make(chan error, 1)
+	s := newSession(t, func(st *Stream) {
+		body := NewBodyReader(st.Recv, nil, nil)
+		if _, err := io.ReadFull(body, make([]byte, 7)); err != nil {
+			handlerDone <- err
+			return
+		}
+		close(started)
+		_, err := io.ReadAll(body)
+		handlerDone <- err
+	})
+
+	st, err := s.client.Open(&ProxyRequest{Verb: "POST"})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := st.sendChunk(&BodyChunk{Data: []byte("partial")}); err != nil {
+		t.Fatalf("Sending part of the body: %v", err)
+	}
+	select {
+	case <-started:
+	case err := <-handlerDone:
+		t.Fatalf("Handler failed reading the start of the body: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the start of the body")
+	}
+	s.clientPipe.CloseSend()
+
+	// The body fails rather than looking complete.
+	if err := receive(t, "the handler", handlerDone); err != errSessionClosed {
+		t.Errorf("Reading the rest of the body returned %v, want %v", err, errSessionClosed)
+	}
+	if err := receive(t, "Run", s.serverDone); err != nil {
+		t.Errorf("Run returned %v once the peer closed the Session, want nil", err)
+	}
+	if _, err := s.server.Open(&ProxyRequest{Verb: "GET"}); err != errSessionClosed {
+		t.Errorf("Open after the Session ended returned %v, want %v", err, errSessionClosed)
+	}
+}
+
+// zeros reads as an endless stream of zero bytes.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,148 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package periscope
+
+import (
+	"errors"
+	"io"
+	"net/http"
+)
+
+// ChunkSize is the largest amount of body data sent in a single BodyChunk.
+const ChunkSize = 32 * 1024
+
+// SendBody sends body as a sequence of BodyChunks, ending with a chunk with
+// End set. Data is sent as soon as it is read rather than
+// when the body is complete. A nil body is sent as an empty one.
+//
+// If trailer is non-nil, it is called once the body has been read, and the
+// trailers it returns (e.g. http.Response.Trailer) are sent with the end.
+func SendBody(body io.Reader, trailer func() http.Header, send func(*BodyChunk) error) error {
+	end := func() *BodyChunk {
+		c := &BodyChunk{End: true}
+		if trailer != nil && len(trailer()) > 0 {
+			c.Trailers = HeadersToProto(trailer())
+		}
+		return c
+	}
+	if body == nil {
+		return send(end())
+	}
+	buf := make([]byte, ChunkSize)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			if err := send(&BodyChunk{Data: data}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return send(end())
+		}
+		if err != nil {
+			send(&BodyChunk{End: true, Error: err.Error()})
+			return err
+		}
+	}
+}
+
+func addTrailers(trailer http.Header, in *BodyChunk) {
+	if trailer == nil {
+		return
+	}
+	for k, v := range HeadersFromProto(in.Trailers) {
+		trailer[k] = v
+	}
+}
+
+type bodyReader struct {
+	recv    func() (*BodyChunk, error)
+	trailer http.Header
+	close   func()
+	pending []byte
+	err     error
+}
+
+// NewBodyReader reassembles a body from the BodyChunks returned by recv,
+// which is called only as the body is read. Any trailers are added to
+// trailer (if non-nil) before the end of the body is returned. close (if
+// non-nil) is called when the body is closed.
+func NewBodyReader(recv func() (*BodyChunk, error), trailer http.Header, close func()) io.ReadCloser {
+	return &bodyReader{recv: recv, trailer: trailer, close: close}
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	for len(b.pending) == 0 {
+		if b.err != nil {
+			return 0, b.err
+		}
+		in, err := b.recv()
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			b.err = err
+			continue
+		}
+		if in == nil {
+			b.err = errors.New("expected body chunk")
+			continue
+		}
+		b.pending = in.Data
+		if in.End {
+			addTrailers(b.trailer, in)
+			b.err = io.EOF
+			if in.Error != "" {
+				b.err = errors.New(in.Error)
+			}
+		}
+	}
+	n := copy(p, b.pending)
+	b.pending = b.pending[n:]
+	return n, nil
+}
+
+func (b *bodyReader) Close() error {
+	if b.close != nil {
+		b.close()
+	}
+	return nil
+}
+
+// FlushingResponseWriter flushes after every Write, so that streamed bodies
+// reach the client as they arrive rather than when a buffer fills.
+type FlushingResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w FlushingResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+	return n, err
+}
+
+// WriteTrailers sends trailer after a response body written to w, using
+// http.TrailerPrefix so that the trailers need not be declared in advance.
+func WriteTrailers(w http.ResponseWriter, trailer http.Header) {
+	for k, v := range trailer {
+		w.Header()[http.TrailerPrefix+k] = v
+	}
+}
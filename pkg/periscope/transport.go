@@ -0,0 +1,69 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package periscope
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// NewTransport returns a RoundTripper which sends requests that arrived as
+// HTTP/2 as cleartext HTTP/2 (h2c) with prior knowledge, and others as
+// HTTP/1.1. dial defaults to net.Dialer.DialContext. HTTP/1 requests are
+// sent through the proxy returned by proxy, if set, such as
+// http.ProxyFromEnvironment; HTTP/2 requests never are.
+func NewTransport(dial func(ctx context.Context, network, addr string) (net.Conn, error), proxy func(*http.Request) (*url.URL, error)) http.RoundTripper {
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	return &protoTransport{
+		h1: &http.Transport{
+			Proxy:       proxy,
+			DialContext: dial,
+		},
+		h2: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return dial(context.Background(), network, addr)
+			},
+		},
+	}
+}
+
+type protoTransport struct {
+	h1 http.RoundTripper
+	h2 http.RoundTripper
+}
+
+func (t *protoTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.ProtoMajor == 2 {
+		return t.h2.RoundTrip(r)
+	}
+	return t.h1.RoundTrip(r)
+}
+
+// H2CHandler serves h as both HTTP/1 and cleartext HTTP/2 (h2c), including
+// HTTP/2 with prior knowledge as gRPC clients use.
+func H2CHandler(h http.Handler) http.Handler {
+	return h2c.NewHandler(h, &http2.Server{})
+}
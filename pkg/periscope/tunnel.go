@@ -0,0 +1,235 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package periscope
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// UDPIdleTimeout is how long a UDP session lasts without traffic in either
+// direction.
+const UDPIdleTimeout = 2 * time.Minute
+
+// TunnelStream is the server side of a Tunnel or Accept call.
+type TunnelStream interface {
+	Send(*TunnelData) error
+	Recv() (*TunnelData, error)
+}
+
+// TunnelClient is the client side of a Tunnel or Accept call.
+type TunnelClient interface {
+	TunnelStream
+	CloseSend() error
+}
+
+// DialTunnel connects to address from the inner proxy, returning once the
+// connection has been made.
+func DialTunnel(ctx context.Context, client PeriscopeClient, address string) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := client.Tunnel(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return openTunnel(stream, cancel, &TunnelOpen{Address: address})
+}
+
+// AcceptTunnel picks up the connection announced by the inner proxy as id.
+func AcceptTunnel(ctx context.Context, client PeriscopeClient, id int64) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := client.Accept(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return openTunnel(stream, cancel, &TunnelOpen{Id: id})
+}
+
+func openTunnel(stream TunnelClient, cancel func(), open *TunnelOpen) (net.Conn, error) {
+	if err := stream.Send(&TunnelData{Kind: &TunnelData_Open{Open: open}}); err != nil {
+		cancel()
+		return nil, err
+	}
+	in, err := stream.Recv()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	ack := in.GetOpen()
+	if ack == nil {
+		cancel()
+		return nil, fmt.Errorf("Expected tunnel open, got %T", in.Kind)
+	}
+	return &tunnelConn{stream: stream, cancel: cancel, remote: tunnelAddr(ack.Address)}, nil
+}
+
+// tunnelConn is a net.Conn carried over a TunnelClient. Deadlines are not
+// supported.
+type tunnelConn struct {
+	stream  TunnelClient
+	cancel  func()
+	remote  tunnelAddr
+	pending []byte
+	// Guards stream.Send, which must not be called concurrently.
+	writeLock sync.Mutex
+}
+
+func (c *tunnelConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		in, err := c.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		c.pending = in.GetData()
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *tunnelConn) Write(p []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	for written := 0; written < len(p); {
+		n := len(p) - written
+		if n > ChunkSize {
+			n = ChunkSize
+		}
+		data := make([]byte, n)
+		copy(data, p[written:])
+		if err := c.stream.Send(&TunnelData{Kind: &TunnelData_Data{Data: data}}); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return len(p), nil
+}
+
+// CloseWrite half-closes the connection, leaving it open for reading.
+func (c *tunnelConn) CloseWrite() error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return c.stream.CloseSend()
+}
+
+func (c *tunnelConn) Close() error {
+	c.cancel()
+	return nil
+}
+
+func (c *tunnelConn) LocalAddr() net.Addr                { return tunnelAddr("periscope") }
+func (c *tunnelConn) RemoteAddr() net.Addr               { return c.remote }
+func (c *tunnelConn) SetDeadline(t time.Time) error      { return nil }
+func (c *tunnelConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *tunnelConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type tunnelAddr string
+
+func (a tunnelAddr) Network() string { return "periscope" }
+func (a tunnelAddr) String() string  { return string(a) }
+
+// Splice relays data between conn and the server side of a tunnel until
+// conn has no more data to send. The caller should return from the RPC (and
+// close conn) afterwards.
+func Splice(conn net.Conn, stream TunnelStream) error {
+	go func() {
+		for {
+			in, err := stream.Recv()
+			if err == io.EOF {
+				closeWrite(conn)
+				return
+			}
+			if err != nil {
+				conn.Close()
+				return
+			}
+			if _, err := conn.Write(in.GetData()); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
+	buf := make([]byte, ChunkSize)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			data := make([]byte, n)
+			copy(data, buf[:n])
+			if err := stream.Send(&TunnelData{Kind: &TunnelData_Data{Data: data}}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Join copies data between a and b in both directions, half-closing each
+// side as the other finishes, and closes both once both directions are done.
+func Join(a, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	pipe := func(dst, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		closeWrite(dst)
+	}
+	go pipe(a, b)
+	go pipe(b, a)
+	wg.Wait()
+	a.Close()
+	b.Close()
+}
+
+func closeWrite(conn net.Conn) error {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		return c.CloseWrite()
+	}
+	return conn.Close()
+}
+
+// WithBuffered returns conn, but reading first any data already buffered by
+// r, such as the bufio.Reader returned by http.Hijacker.
+func WithBuffered(conn net.Conn, r *bufio.Reader) net.Conn {
+	if r == nil || r.Buffered() == 0 {
+		return conn
+	}
+	return &bufferedConn{Conn: conn, r: r}
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *bufferedConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
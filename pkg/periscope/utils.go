@@ -17,81 +17,119 @@ limitations under the License.
 package periscope
 
 import (
-	"bytes"
-	"io"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 )
 
+// The body of the returned request is unset; it arrives separately as
+// BodyChunks.
 func ReqToHttp(in *ProxyRequest) (*http.Request, error) {
 	url, err := url.Parse(in.Target)
 	if err != nil {
 		return nil, err
 	}
 	url.Host = in.Host
-	headers := make(http.Header, len(in.Headers))
-	for k, v := range in.Headers {
-		headers.Set(k, v)
+	headers := HeadersFromProto(in.Headers)
+	req := &http.Request{
+		Method:        in.Verb,
+		URL:           url,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        headers,
+		ContentLength: contentLength(headers),
 	}
-	return &http.Request{
-		Method: in.Verb,
-		URL:    url,
-		Header: headers,
-		Body:   io.NopCloser(bytes.NewReader(in.Body)),
-	}, nil
+	if major, minor, ok := http.ParseHTTPVersion(in.Proto); ok {
+		req.Proto, req.ProtoMajor, req.ProtoMinor = in.Proto, major, minor
+	}
+	return req, nil
 }
 
+// The body of the returned response is unset; it arrives separately as
+// BodyChunks.
 func RespToHttp(in *ProxyResponse) (*http.Response, error) {
-	headers := make(http.Header, len(in.Headers))
-	for k, v := range in.Headers {
-		headers.Set(k, v)
-	}
+	headers := HeadersFromProto(in.Headers)
 	return &http.Response{
-		StatusCode: int(in.Status),
-		Status:     in.Reason,
-		Header:     headers,
-		Body:       io.NopCloser(bytes.NewReader(in.Body)),
+		StatusCode:    int(in.Status),
+		Status:        in.Reason,
+		Header:        headers,
+		ContentLength: contentLength(headers),
 	}, nil
 }
 
-// Closes in.Body
-func HttpToResp(in http.Response) (*ProxyResponse, error) {
-	defer in.Body.Close()
-	body, err := io.ReadAll(in.Body)
-	if err != nil {
-		return nil, err
-	}
-	headers := make(map[string]string, len(in.Header))
-	for k, v := range in.Header {
-		// https://datatracker.ietf.org/doc/html/rfc7230#section-3.2.2
-		headers[k] = strings.Join(v, ",")
-	}
+// Does not consume in.Body; send it with SendBody.
+func HttpToResp(in *http.Response) *ProxyResponse {
 	return &ProxyResponse{
 		Status:  int32(in.StatusCode),
 		Reason:  in.Status,
-		Headers: headers,
-		Body:    body,
-	}, nil
+		Headers: HeadersToProto(in.Header),
+	}
 }
 
-// Closes in.Body
-func HttpToReq(in http.Request) (*ProxyRequest, error) {
-	defer in.Body.Close()
-	body, err := io.ReadAll(in.Body)
-	if err != nil {
-		return nil, err
-	}
-	headers := make(map[string]string, len(in.Header))
-	for k, v := range in.Header {
-		// https://datatracker.ietf.org/doc/html/rfc7230#section-3.2.2
-		headers[k] = strings.Join(v, ",")
-	}
+// Does not consume in.Body; send it with SendBody.
+func HttpToReq(in *http.Request) *ProxyRequest {
 	return &ProxyRequest{
 		Verb:    in.Method,
 		Target:  in.RequestURI,
 		Host:    in.Host,
-		Headers: headers,
-		Body:    body,
-	}, nil
+		Headers: HeadersToProto(in.Header),
+		Proto:   in.Proto,
+	}
+}
+
+// HeadersToProto converts h to header fields, keeping each value separate
+// and in order. net/http doesn't keep the order of different fields, so
+// they are sorted by name.
+func HeadersToProto(h http.Header) []*Header {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var headers []*Header
+	for _, name := range names {
+		for _, v := range h[name] {
+			headers = append(headers, &Header{Name: name, Value: v})
+		}
+	}
+	return headers
+}
+
+// HeadersFromProto rebuilds the http.Header converted by HeadersToProto,
+// keeping the names exactly as sent.
+func HeadersFromProto(in []*Header) http.Header {
+	headers := make(http.Header, len(in))
+	for _, h := range in {
+		headers[h.Name] = append(headers[h.Name], h.Value)
+	}
+	return headers
+}
+
+// IsUpgrade reports whether h asks to switch protocols (e.g. to WebSockets),
+// which needs a raw connection rather than request/response proxying.
+func IsUpgrade(h http.Header) bool {
+	if h.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range h.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contentLength returns -1 (unknown) unless headers carries a valid
+// Content-Length.
+func contentLength(headers http.Header) int64 {
+	n, err := strconv.ParseInt(headers.Get("Content-Length"), 10, 64)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
 }
@@ -0,0 +1,58 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package periscope
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestHeadersRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   http.Header
+	}{
+		{name: "empty", in: http.Header{}},
+		{name: "single", in: http.Header{"Content-Type": {"application/json"}}},
+		{name: "repeated", in: http.Header{"Set-Cookie": {"a=1", "b=2", "c=3"}, "Vary": {"Accept"}}},
+		{name: "non-canonical", in: http.Header{"x-lower": {"v"}, "X-Lower": {"w"}}},
+		{name: "empty value", in: http.Header{"X-Empty": {""}}},
+		{name: "trailer names", in: http.Header{"Trailer": {"Grpc-Status, Grpc-Message"}, "Te": {"trailers"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := HeadersFromProto(HeadersToProto(tt.in))
+			if !reflect.DeepEqual(got, tt.in) {
+				t.Errorf("Round trip of %v gave %v", tt.in, got)
+			}
+		})
+	}
+}
+
+func TestHeadersToProtoOrder(t *testing.T) {
+	got := HeadersToProto(http.Header{"B": {"1", "2"}, "A": {"3"}})
+	want := []*Header{{Name: "A", Value: "3"}, {Name: "B", Value: "1"}, {Name: "B", Value: "2"}}
+	if len(got) != len(want) {
+		t.Fatalf("HeadersToProto gave %d headers, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Name != want[i].Name || got[i].Value != want[i].Value {
+			t.Errorf("Header %d is %s: %s, want %s: %s", i, got[i].Name, got[i].Value, want[i].Name, want[i].Value)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package periscope
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// ProtocolVersion must be bumped by any change to the Periscope service
+// which peers built before it can't handle, along with the
+// periscope/protocol annotation in config/remote-pod.yaml (after which the
+// image must be republished with hack/update-pod-config.sh). Additions which
+// older peers can ignore should be capabilities instead.
+//
+// Version 2 replaced In and Out with Session.
+const ProtocolVersion = 2
+
+// Capabilities of the Periscope service beyond Session.
+const (
+	// Upgraded requests are relayed with Tunnel and Accept.
+	CapUpgrade = "upgrade"
+	// Tunnel connects to arbitrary addresses (CONNECT and -L).
+	CapTunnel = "tunnel"
+	// Listen opens extra ports in the cluster (-R).
+	CapListen = "listen"
+	// Datagrams relays UDP (-U).
+	CapDatagrams = "datagrams"
+	// HTTP/2 requests are sent on as h2c, with trailers.
+	CapH2C = "h2c"
+	// The outside proxy reports the health of its target on the Session.
+	CapHealth = "health"
+	// Sessions may be named, and requests routed between them.
+	CapSessions = "sessions"
+	// SetRules and GetRules split requests between a session and the
+	// fallback.
+	CapRules = "rules"
+	// Rules may mirror requests to a session rather than send them there.
+	CapMirror = "mirror"
+	// Mirrored copies carry the fallback's answer, to compare with, if
+	// the session's rules ask to shadow.
+	CapShadow = "shadow"
+	// Rules may name the session's own fallback.
+	CapFallback = "fallback"
+)
+
+// Capabilities lists the capabilities of this build.
+var Capabilities = []string{CapUpgrade, CapTunnel, CapListen, CapDatagrams, CapH2C, CapHealth, CapSessions, CapRules, CapMirror, CapShadow, CapFallback}
+
+// Version is the build version of this binary. It may be set at link time
+// with -ldflags "-X github.com/evankanderson/periscope/pkg/periscope.Version=v1.2.3",
+// and otherwise comes from the module build info.
+var Version = ""
+
+// BuildVersion returns Version, or the module version if it is unset.
+func BuildVersion() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return "(unknown)"
+}
+
+// NewHandshake returns the Handshake describing this build.
+func NewHandshake() *Handshake {
+	return &Handshake{
+		Version:      BuildVersion(),
+		Protocol:     ProtocolVersion,
+		Capabilities: Capabilities,
+	}
+}
+
+// Negotiate checks that the peer which sent peer can work with this build,
+// returning the capabilities both support.
+func Negotiate(peer *Handshake) (map[string]bool, error) {
+	if peer.Protocol != ProtocolVersion {
+		return nil, fmt.Errorf("periscope %s speaks protocol version %d, but periscope %s speaks version %d",
+			peer.Version, peer.Protocol, BuildVersion(), ProtocolVersion)
+	}
+	theirs := make(map[string]bool, len(peer.Capabilities))
+	for _, c := range peer.Capabilities {
+		theirs[c] = true
+	}
+	both := make(map[string]bool, len(Capabilities))
+	for _, c := range Capabilities {
+		if theirs[c] {
+			both[c] = true
+		}
+	}
+	return both, nil
+}
+
+// FormatCapabilities lists caps for messages.
+func FormatCapabilities(caps map[string]bool) string {
+	names := make([]string, 0, len(caps))
+	for c := range caps {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
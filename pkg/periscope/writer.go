@@ -0,0 +1,87 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package periscope
+
+import (
+	"context"
+)
+
+// SendQueueSize is how many messages a Writer holds before senders wait.
+const SendQueueSize = 64
+
+// MessageStream is the sending half of any gRPC stream.
+type MessageStream interface {
+	SendMsg(m interface{}) error
+	Context() context.Context
+}
+
+// Writer owns the sending half of a gRPC stream, which doesn't allow
+// concurrent Sends. Other goroutines queue messages for it, and wait while
+// the queue is full, so a slow peer holds senders back rather than letting
+// messages pile up.
+type Writer struct {
+	stream MessageStream
+	queue  chan interface{}
+	// Closed when the writer stops, after err is set.
+	done chan struct{}
+	err  error
+}
+
+// NewWriter starts a Writer for stream, which runs until the stream ends or
+// a Send on it fails.
+func NewWriter(stream MessageStream) *Writer {
+	w := &Writer{
+		stream: stream,
+		queue:  make(chan interface{}, SendQueueSize),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+	for {
+		select {
+		case m := <-w.queue:
+			if err := w.stream.SendMsg(m); err != nil {
+				w.err = err
+				return
+			}
+		case <-w.stream.Context().Done():
+			w.err = w.stream.Context().Err()
+			return
+		}
+	}
+}
+
+// Send queues m to be sent in order after everything queued before it. It
+// fails once the writer has stopped, but success doesn't mean m reached the
+// peer.
+func (w *Writer) Send(m interface{}) error {
+	select {
+	case <-w.done:
+		return w.err
+	default:
+	}
+	select {
+	case w.queue <- m:
+		return nil
+	case <-w.done:
+		return w.err
+	}
+}
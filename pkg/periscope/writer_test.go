@@ -0,0 +1,95 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package periscope
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stuckStream sends nothing until released.
+type stuckStream struct {
+	ctx     context.Context
+	release chan struct{}
+	sent    chan interface{}
+}
+
+func (s *stuckStream) SendMsg(m interface{}) error {
+	select {
+	case <-s.release:
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+	s.sent <- m
+	return nil
+}
+
+func (s *stuckStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestWriterQueueBound(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream := &stuckStream{ctx: ctx, release: make(chan struct{}), sent: make(chan interface{}, 2*SendQueueSize)}
+	w := NewWriter(stream)
+
+	// One message is held by the stuck SendMsg, and SendQueueSize queued.
+	const total = SendQueueSize + 5
+	var queued int32
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < total; i++ {
+			if err := w.Send(i); err != nil {
+				done <- err
+				return
+			}
+			atomic.AddInt32(&queued, 1)
+		}
+		done <- nil
+	}()
+	eventually(t, "the queue to fill", func() bool { return atomic.LoadInt32(&queued) >= SendQueueSize })
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&queued); got > SendQueueSize+1 {
+		t.Errorf("Queued %d messages on a stuck stream, want at most %d", got, SendQueueSize+1)
+	}
+
+	close(stream.release)
+	if err := receive(t, "the sender", done); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	for i := 0; i < total; i++ {
+		select {
+		case m := <-stream.sent:
+			if m != i {
+				t.Fatalf("Message %d was %v, want messages in order", i, m)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for message %d", i)
+		}
+	}
+
+	// Once the stream ends, Send fails rather than queueing.
+	cancel()
+	eventually(t, "Send to fail", func() bool { return w.Send("late") != nil })
+	if err := w.Send("late"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Send after the stream ended returned %v, want %v", err, context.Canceled)
+	}
+}
@@ -17,12 +17,19 @@ limitations under the License.
 package remote
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	_ "embed"
+	"encoding/json"
 	"fmt"
+	"log"
 	"os/exec"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/evankanderson/periscope/pkg/periscope"
 )
 
 func EnsureTools() error {
@@ -52,9 +59,24 @@ func StartForward(ctx context.Context, podname string, port int) (string, error,
 //go:embed pod-config.yaml
 var manifest []byte
 
-func EnsureForwarder() error {
+// ProtocolAnnotation holds the periscope.ProtocolVersion which an inner
+// proxy pod's image speaks.
+const ProtocolAnnotation = "periscope/protocol"
+
+// EnsureForwarder starts periscope-remote-proxy, running image, or the image
+// in the embedded manifest if that is "". A pod running another image (e.g.
+// from another release, which may not speak this one's protocol) is
+// replaced.
+func EnsureForwarder(image string) error {
+	image, err := innerImage(image)
+	if err != nil {
+		return err
+	}
+	if err := replaceForwarder(image); err != nil {
+		return err
+	}
 	cmd := exec.Command("kubectl", "apply", "-f", "-")
-	cmd.Stdin = bytes.NewReader(manifest)
+	cmd.Stdin = bytes.NewReader(renderManifest(image))
 	if out, err := cmd.Output(); err != nil {
 		if exit, ok := err.(*exec.ExitError); ok && out == nil {
 			out = exit.Stderr
@@ -74,3 +96,185 @@ func EnsureForwarder() error {
 
 	return nil
 }
+
+// replaceForwarder deletes periscope-remote-proxy's pod, if it runs another
+// image than image or speaks another protocol.
+func replaceForwarder(image string) error {
+	out, err := kubectl(nil, "get", "pod", proxyLabel, "--ignore-not-found", "-o", "json")
+	if err != nil {
+		return fmt.Errorf("Unable to get pod/%s:\n%s", proxyLabel, err)
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil
+	}
+	var pod struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+		Spec struct {
+			Containers []struct {
+				Image string `json:"image"`
+			} `json:"containers"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(out, &pod); err != nil {
+		return fmt.Errorf("Unable to parse pod/%s: %w", proxyLabel, err)
+	}
+	running := ""
+	if len(pod.Spec.Containers) > 0 {
+		running = pod.Spec.Containers[0].Image
+	}
+	protocol := pod.Metadata.Annotations[ProtocolAnnotation]
+	if running == image && protocol == fmt.Sprint(periscope.ProtocolVersion) {
+		return nil
+	}
+	if protocol == "" {
+		protocol = "1"
+	}
+	log.Printf("Replacing pod/%s, which runs %s (protocol %s)...", proxyLabel, running, protocol)
+	if _, err := kubectl(nil, "delete", "pod", proxyLabel, "--ignore-not-found"); err != nil {
+		return fmt.Errorf("Unable to delete pod/%s:\n%s", proxyLabel, err)
+	}
+	return nil
+}
+
+// innerImage returns the inner proxy's image: image, if set, and otherwise
+// the one in the embedded manifest, as long as it speaks this build's
+// protocol.
+func innerImage(image string) (string, error) {
+	if image != "" {
+		return image, nil
+	}
+	image = manifestValue("image")
+	if image == "" {
+		return "", fmt.Errorf("No image in the embedded pod-config.yaml")
+	}
+	protocol, _ := strconv.Atoi(manifestValue(ProtocolAnnotation))
+	if protocol != periscope.ProtocolVersion {
+		return "", fmt.Errorf("The inner proxy image built into periscope %s speaks protocol %d, not %d. Use a periscope release, or build the image (e.g. with `ko build ./cmd/inner`) and pass it with --image",
+			periscope.BuildVersion(), protocol, periscope.ProtocolVersion)
+	}
+	return image, nil
+}
+
+// manifestValue returns the first value of key in the embedded manifest.
+func manifestValue(key string) string {
+	scanner := bufio.NewScanner(bytes.NewReader(manifest))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, key+":") {
+			return strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, key+":")), `"`)
+		}
+	}
+	return ""
+}
+
+// renderManifest returns the embedded manifest, running image.
+func renderManifest(image string) []byte {
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(manifest))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		indent := line[:len(line)-len(strings.TrimLeft(line, " "))]
+		switch {
+		case strings.HasPrefix(trimmed, "image:"):
+			line = fmt.Sprintf("%simage: %s", indent, image)
+		case strings.HasPrefix(trimmed, ProtocolAnnotation+":"):
+			line = fmt.Sprintf("%s%s: \"%d\"", indent, ProtocolAnnotation, periscope.ProtocolVersion)
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// ExposePorts adds TCP ports to the periscope-remote-proxy Service, so that
+// ports the inner proxy listens on for reverse forwards can be reached. The
+// returned function removes the ports it added; ports the Service already
+// had are left alone.
+func ExposePorts(ports []int) (func() error, error) {
+	svc, err := getService(proxyLabel)
+	if err != nil {
+		return nil, err
+	}
+	had := map[int]bool{}
+	for _, p := range svc.Spec.Ports {
+		if n, ok := p["port"].(float64); ok {
+			had[int(n)] = true
+		}
+	}
+	var ops []patchOp
+	var added []int
+	for _, port := range ports {
+		if had[port] {
+			continue
+		}
+		had[port] = true
+		added = append(added, port)
+		ops = append(ops, patchOp{"add", "/spec/ports/-", map[string]interface{}{
+			"name":       fmt.Sprintf("tcp-%d", port),
+			"port":       port,
+			"targetPort": port,
+			"protocol":   "TCP",
+		}})
+	}
+	if len(ops) > 0 {
+		if err := patchService(proxyLabel, ops); err != nil {
+			return nil, fmt.Errorf("Unable to expose ports:\n%s", err)
+		}
+	}
+	return func() error { return unexposePorts(added) }, nil
+}
+
+// unexposePorts removes ports from the periscope-remote-proxy Service.
+func unexposePorts(ports []int) error {
+	if len(ports) == 0 {
+		return nil
+	}
+	svc, err := getService(proxyLabel)
+	if err != nil {
+		return err
+	}
+	remove := map[int]bool{}
+	for _, port := range ports {
+		remove[port] = true
+	}
+	// Remove from the end, so that earlier indices stay put.
+	var ops []patchOp
+	for i := len(svc.Spec.Ports) - 1; i >= 0; i-- {
+		if n, ok := svc.Spec.Ports[i]["port"].(float64); ok && remove[int(n)] {
+			ops = append(ops, patchOp{"remove", fmt.Sprintf("/spec/ports/%d", i), nil})
+		}
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	if err := patchService(proxyLabel, ops); err != nil {
+		return fmt.Errorf("Unable to remove exposed ports:\n%s", err)
+	}
+	return nil
+}
+
+// CurrentContext returns the names of the kubeconfig context and cluster
+// which kubectl uses.
+func CurrentContext() (string, string, error) {
+	out, err := kubectl(nil, "config", "view", "--minify", "-o", "json")
+	if err != nil {
+		return "", "", fmt.Errorf("Unable to read kubeconfig:\n%s", err)
+	}
+	var config struct {
+		CurrentContext string `json:"current-context"`
+		Clusters       []struct {
+			Name string `json:"name"`
+		} `json:"clusters"`
+	}
+	if err := json.Unmarshal(out, &config); err != nil {
+		return "", "", fmt.Errorf("Unable to parse kubeconfig: %w", err)
+	}
+	cluster := ""
+	if len(config.Clusters) > 0 {
+		cluster = config.Clusters[0].Name
+	}
+	return config.CurrentContext, cluster, nil
+}
@@ -0,0 +1,279 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+const (
+	// InterceptAnnotation holds the selector and ports of a Service while
+	// periscope intercepts it, so that they can be restored.
+	InterceptAnnotation = "periscope/intercepted"
+	// proxyLabel is the app label of periscope-remote-proxy's pod; see
+	// pod-config.yaml.
+	proxyLabel = "periscope-remote-proxy"
+	// proxyPort is the name of the pod's port for requests out of the cluster.
+	proxyPort = "local-proxy"
+)
+
+// interceptState is what InterceptAnnotation holds.
+type interceptState struct {
+	Selector map[string]string `json:"selector"`
+	Ports    json.RawMessage   `json:"ports"`
+}
+
+type service struct {
+	Metadata struct {
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		Selector   map[string]string        `json:"selector"`
+		Ports      []map[string]interface{} `json:"ports"`
+		IPFamilies []string                 `json:"ipFamilies"`
+	} `json:"spec"`
+}
+
+// port returns the Service name's port, which may be 0 if it has only one.
+func (svc *service) port(name string, port int) (map[string]interface{}, error) {
+	for _, p := range svc.Spec.Ports {
+		if n, _ := p["port"].(float64); port == 0 && len(svc.Spec.Ports) == 1 || int(n) == port {
+			return p, nil
+		}
+	}
+	if port == 0 {
+		return nil, fmt.Errorf("svc/%s has several ports; pick one with --service-port", name)
+	}
+	return nil, fmt.Errorf("svc/%s has no port %d", name, port)
+}
+
+// ParseService returns the name of the Service in spec, given as svc/name,
+// service/name or name.
+func ParseService(spec string) (string, error) {
+	name := spec
+	if i := strings.Index(spec, "/"); i >= 0 {
+		switch spec[:i] {
+		case "svc", "service", "services":
+			name = spec[i+1:]
+		default:
+			return "", fmt.Errorf("Expected a Service as svc/<name>, got %q", spec)
+		}
+	}
+	if name == "" {
+		return "", fmt.Errorf("Expected a Service as svc/<name>, got %q", spec)
+	}
+	return name, nil
+}
+
+// OriginalService returns the name of the Service which InterceptService
+// leaves selecting the intercepted Service name's pods on port 80, as a
+// fallback which doesn't lead back to periscope.
+func OriginalService(name string) string {
+	return "periscope-original-" + name
+}
+
+// InterceptService points the Service name's port (which may be 0 if it has
+// only one) at periscope-remote-proxy, keeping its selector and ports in
+// InterceptAnnotation, and starts OriginalService in its place. If the
+// Service is still intercepted from a run which didn't restore it, it is
+// restored first. The returned function restores it.
+func InterceptService(name string, port int) (func() error, error) {
+	if name == proxyLabel {
+		return nil, fmt.Errorf("Can't intercept periscope's own Service")
+	}
+	svc, err := getService(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := svc.Metadata.Annotations[InterceptAnnotation]; ok {
+		log.Printf("Restoring svc/%s, left intercepted by an earlier run", name)
+		if err := RestoreService(name); err != nil {
+			return nil, err
+		}
+		if svc, err = getService(name); err != nil {
+			return nil, err
+		}
+	}
+	if len(svc.Spec.Selector) == 0 {
+		return nil, fmt.Errorf("svc/%s has no selector to retarget", name)
+	}
+
+	original, err := json.Marshal(svc.Spec.Ports)
+	if err != nil {
+		return nil, err
+	}
+	state, err := json.Marshal(interceptState{Selector: svc.Spec.Selector, Ports: original})
+	if err != nil {
+		return nil, err
+	}
+	intercepted, err := svc.port(name, port)
+	if err != nil {
+		return nil, err
+	}
+	if err := startOriginal(name, svc.Spec.Selector, intercepted); err != nil {
+		return nil, err
+	}
+	intercepted["targetPort"] = proxyPort
+	for _, p := range svc.Spec.Ports {
+		if p["port"] != intercepted["port"] {
+			log.Printf("svc/%s port %v won't be served while intercepted", name, p["port"])
+		}
+	}
+
+	ops := []patchOp{
+		{"replace", "/spec/selector", map[string]string{"app": proxyLabel}},
+		{"replace", "/spec/ports", svc.Spec.Ports},
+	}
+	if svc.Metadata.Annotations == nil {
+		ops = append(ops, patchOp{"add", "/metadata/annotations", map[string]string{InterceptAnnotation: string(state)}})
+	} else {
+		ops = append(ops, patchOp{"add", annotationPath, string(state)})
+	}
+	if err := patchService(name, ops); err != nil {
+		if err := deleteOriginal(name); err != nil {
+			log.Print(err)
+		}
+		return nil, fmt.Errorf("Unable to intercept svc/%s:\n%s", name, err)
+	}
+	return func() error { return RestoreService(name) }, nil
+}
+
+// startOriginal applies OriginalService for the Service name, selecting its
+// pods with selector on port's targetPort.
+func startOriginal(name string, selector map[string]string, port map[string]interface{}) error {
+	targetPort := port["targetPort"]
+	if targetPort == nil {
+		targetPort = port["port"]
+	}
+	protocol, _ := port["protocol"].(string)
+	if protocol == "" {
+		protocol = "TCP"
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata": map[string]interface{}{
+			"name":   OriginalService(name),
+			"labels": map[string]string{"app.kubernetes.io/managed-by": "periscope"},
+		},
+		"spec": map[string]interface{}{
+			"selector": selector,
+			"ports": []interface{}{map[string]interface{}{
+				"name":       "http",
+				"port":       80,
+				"targetPort": targetPort,
+				"protocol":   protocol,
+			}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := kubectl(body, "apply", "-f", "-"); err != nil {
+		return fmt.Errorf("Unable to create svc/%s:\n%s", OriginalService(name), err)
+	}
+	return nil
+}
+
+func deleteOriginal(name string) error {
+	if _, err := kubectl(nil, "delete", "service", OriginalService(name), "--ignore-not-found"); err != nil {
+		return fmt.Errorf("Unable to delete svc/%s:\n%s", OriginalService(name), err)
+	}
+	return nil
+}
+
+// RestoreService restores the selector and ports kept in the Service name's
+// InterceptAnnotation, if it has one, and deletes its OriginalService.
+func RestoreService(name string) error {
+	svc, err := getService(name)
+	if err != nil {
+		return err
+	}
+	saved, ok := svc.Metadata.Annotations[InterceptAnnotation]
+	if !ok {
+		return deleteOriginal(name)
+	}
+	var state interceptState
+	if err := json.Unmarshal([]byte(saved), &state); err != nil {
+		return fmt.Errorf("Unable to parse %s of svc/%s: %w", InterceptAnnotation, name, err)
+	}
+	if err := patchService(name, []patchOp{
+		{"replace", "/spec/selector", state.Selector},
+		{"replace", "/spec/ports", state.Ports},
+		{"remove", annotationPath, nil},
+	}); err != nil {
+		return fmt.Errorf("Unable to restore svc/%s:\n%s", name, err)
+	}
+	log.Printf("Restored svc/%s", name)
+	return deleteOriginal(name)
+}
+
+// annotationPath is InterceptAnnotation as a JSON Pointer.
+var annotationPath = "/metadata/annotations/" + strings.ReplaceAll(InterceptAnnotation, "/", "~1")
+
+// A patchOp is an RFC 6902 JSON Patch operation.
+type patchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+func getService(name string) (*service, error) {
+	out, err := kubectl(nil, "get", "service", name, "-o", "json")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get svc/%s:\n%s", name, err)
+	}
+	svc := &service{}
+	if err := json.Unmarshal(out, svc); err != nil {
+		return nil, fmt.Errorf("Unable to parse svc/%s: %w", name, err)
+	}
+	return svc, nil
+}
+
+func patchService(name string, ops []patchOp) error {
+	body, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+	_, err = kubectl(nil, "patch", "service", name, "--type=json", "-p", string(body))
+	return err
+}
+
+// kubectl runs kubectl with args, returning its output, or an error holding
+// what it printed on failure.
+func kubectl(stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("kubectl", args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		if exit, ok := err.(*exec.ExitError); ok && len(exit.Stderr) > 0 {
+			out = exit.Stderr
+		}
+		if len(bytes.TrimSpace(out)) == 0 {
+			return nil, fmt.Errorf("kubectl %s: %w", args[0], err)
+		}
+		return nil, fmt.Errorf("%s", bytes.TrimSpace(out))
+	}
+	return out, nil
+}
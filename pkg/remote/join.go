@@ -0,0 +1,152 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+)
+
+// proxyContainerPort is the pod's port for requests out of the cluster; see
+// pod-config.yaml.
+const proxyContainerPort = 8080
+
+// JoinService adds periscope-remote-proxy to the endpoints of the Service
+// name's port (which may be 0 if it has only one) with an EndpointSlice, so
+// that it gets a share of the requests alongside the Service's pods. The
+// returned function removes it again.
+//
+// A slice left behind by a run which didn't remove it is replaced by the
+// next run, or can be deleted with kubectl.
+func JoinService(name string, port int) (func() error, error) {
+	svc, err := getService(name)
+	if err != nil {
+		return nil, err
+	}
+	p, err := svc.port(name, port)
+	if err != nil {
+		return nil, err
+	}
+	// A dual-stack Service's endpoints are of its primary family.
+	family := ""
+	if len(svc.Spec.IPFamilies) > 0 {
+		family = svc.Spec.IPFamilies[0]
+	}
+	ip, addressType, err := proxyIP(family)
+	if err != nil {
+		return nil, err
+	}
+	portName, _ := p["name"].(string)
+	protocol, _ := p["protocol"].(string)
+	if protocol == "" {
+		protocol = "TCP"
+	}
+	slice := map[string]interface{}{
+		"apiVersion": "discovery.k8s.io/v1",
+		"kind":       "EndpointSlice",
+		"metadata": map[string]interface{}{
+			"name": sliceName(name),
+			"labels": map[string]string{
+				// Makes kube-proxy send the Service's traffic here, and the
+				// EndpointSlice controller leave the slice alone.
+				"kubernetes.io/service-name":             name,
+				"endpointslice.kubernetes.io/managed-by": "periscope",
+			},
+		},
+		"addressType": addressType,
+		"endpoints": []interface{}{map[string]interface{}{
+			"addresses":  []string{ip},
+			"conditions": map[string]bool{"ready": true},
+			"targetRef":  map[string]string{"kind": "Pod", "name": proxyLabel},
+		}},
+		// The name ties the slice's port to the Service's.
+		"ports": []interface{}{map[string]interface{}{
+			"name":     portName,
+			"port":     proxyContainerPort,
+			"protocol": protocol,
+		}},
+	}
+	body, err := json.Marshal(slice)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := kubectl(body, "apply", "-f", "-"); err != nil {
+		return nil, fmt.Errorf("Unable to join svc/%s:\n%s", name, err)
+	}
+	return func() error { return LeaveService(name) }, nil
+}
+
+// LeaveService removes periscope-remote-proxy from the endpoints of the
+// Service name, if JoinService added it.
+func LeaveService(name string) error {
+	if _, err := kubectl(nil, "delete", "endpointslice", sliceName(name), "--ignore-not-found"); err != nil {
+		return fmt.Errorf("Unable to leave svc/%s:\n%s", name, err)
+	}
+	log.Printf("Left svc/%s", name)
+	return nil
+}
+
+func sliceName(service string) string {
+	return "periscope-" + service
+}
+
+// proxyIP returns the address of periscope-remote-proxy's pod in family
+// ("IPv4" or "IPv6"), or its primary address if family is "", along with the
+// address's family.
+func proxyIP(family string) (string, string, error) {
+	out, err := kubectl(nil, "get", "pod", proxyLabel, "-o", "json")
+	if err != nil {
+		return "", "", fmt.Errorf("Unable to get pod/%s:\n%s", proxyLabel, err)
+	}
+	var pod struct {
+		Status struct {
+			PodIP  string `json:"podIP"`
+			PodIPs []struct {
+				IP string `json:"ip"`
+			} `json:"podIPs"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(out, &pod); err != nil {
+		return "", "", fmt.Errorf("Unable to parse pod/%s: %w", proxyLabel, err)
+	}
+	ips := []string{pod.Status.PodIP}
+	for _, ip := range pod.Status.PodIPs {
+		ips = append(ips, ip.IP)
+	}
+	for _, ip := range ips {
+		if ip == "" {
+			continue
+		}
+		if f := ipFamily(ip); family == "" || f == family {
+			return ip, f, nil
+		}
+	}
+	if family != "" && pod.Status.PodIP != "" {
+		return "", "", fmt.Errorf("pod/%s has no %s address", proxyLabel, family)
+	}
+	return "", "", fmt.Errorf("pod/%s has no IP yet", proxyLabel)
+}
+
+// ipFamily returns the EndpointSlice addressType of ip.
+func ipFamily(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		return "IPv6"
+	}
+	return "IPv4"
+}
@@ -0,0 +1,284 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// SwapAnnotation holds the replicas of a Deployment while periscope swaps
+// it out, so that they can be restored.
+const SwapAnnotation = "periscope/swapped"
+
+// A Swap replaces the pods of a Deployment with an inner proxy pod carrying
+// the same labels, ports and service account.
+type Swap struct {
+	Deployment string
+	// The pod to start, and the port of its GRPC service.
+	Pod      string
+	GRPCPort int
+	// The port the pod takes requests out of the cluster on.
+	HTTPPort int
+	// The Deployment's other TCP ports, to forward back as they are.
+	Ports []int
+	// If set, the inner proxy image to run, rather than the embedded one.
+	Image string
+
+	uid            string
+	labels         map[string]string
+	serviceAccount string
+	containerPorts []containerPort
+}
+
+type containerPort struct {
+	Name          string `json:"name,omitempty"`
+	ContainerPort int    `json:"containerPort"`
+	Protocol      string `json:"protocol,omitempty"`
+}
+
+type deployment struct {
+	Metadata struct {
+		UID         string            `json:"uid"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+	Spec struct {
+		Replicas *int `json:"replicas"`
+		Template struct {
+			Metadata struct {
+				Labels map[string]string `json:"labels"`
+			} `json:"metadata"`
+			Spec struct {
+				ServiceAccountName string `json:"serviceAccountName"`
+				Containers         []struct {
+					Ports []containerPort `json:"ports"`
+				} `json:"containers"`
+			} `json:"spec"`
+		} `json:"template"`
+	} `json:"spec"`
+}
+
+// swapState is what SwapAnnotation holds.
+type swapState struct {
+	Replicas int `json:"replicas"`
+}
+
+// ParseDeployment returns the name of the Deployment in spec, given as
+// deploy/name, deployment/name or name.
+func ParseDeployment(spec string) (string, error) {
+	name := spec
+	if i := strings.Index(spec, "/"); i >= 0 {
+		switch spec[:i] {
+		case "deploy", "deployment", "deployments":
+			name = spec[i+1:]
+		default:
+			return "", fmt.Errorf("Expected a Deployment as deploy/<name>, got %q", spec)
+		}
+	}
+	if name == "" {
+		return "", fmt.Errorf("Expected a Deployment as deploy/<name>, got %q", spec)
+	}
+	return name, nil
+}
+
+// PlanSwap works out how to swap out the Deployment name, taking requests
+// on httpPort, or on its first port if that is 0.
+func PlanSwap(name string, httpPort int) (*Swap, error) {
+	d, err := getDeployment(name)
+	if err != nil {
+		return nil, err
+	}
+	s := &Swap{
+		Deployment:     name,
+		Pod:            swapPod(name),
+		HTTPPort:       httpPort,
+		uid:            d.Metadata.UID,
+		labels:         d.Spec.Template.Metadata.Labels,
+		serviceAccount: d.Spec.Template.Spec.ServiceAccountName,
+	}
+	seen := map[int]bool{}
+	for _, c := range d.Spec.Template.Spec.Containers {
+		for _, p := range c.Ports {
+			if seen[p.ContainerPort] {
+				continue
+			}
+			seen[p.ContainerPort] = true
+			s.containerPorts = append(s.containerPorts, p)
+			if p.Protocol != "" && p.Protocol != "TCP" {
+				log.Printf("deploy/%s port %d/%s won't be served while swapped", name, p.ContainerPort, p.Protocol)
+				continue
+			}
+			if s.HTTPPort == 0 {
+				s.HTTPPort = p.ContainerPort
+			}
+			if p.ContainerPort != s.HTTPPort {
+				s.Ports = append(s.Ports, p.ContainerPort)
+			}
+		}
+	}
+	if s.HTTPPort == 0 {
+		return nil, fmt.Errorf("deploy/%s declares no ports; pick one with --http-port", name)
+	}
+	if !seen[s.HTTPPort] {
+		s.containerPorts = append(s.containerPorts, containerPort{ContainerPort: s.HTTPPort})
+		seen[s.HTTPPort] = true
+	}
+	for s.GRPCPort = 5000; seen[s.GRPCPort]; s.GRPCPort++ {
+	}
+	return s, nil
+}
+
+// Start scales the Deployment to zero, keeping its replicas in
+// SwapAnnotation, and starts the pod in its place. If the Deployment is
+// still swapped out by a run which didn't restore it, it is restored first.
+// The returned function restores it.
+func (s *Swap) Start() (func() error, error) {
+	d, err := getDeployment(s.Deployment)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := d.Metadata.Annotations[SwapAnnotation]; ok {
+		log.Printf("Restoring deploy/%s, left swapped out by an earlier run", s.Deployment)
+		if err := RestoreDeployment(s.Deployment); err != nil {
+			return nil, err
+		}
+		if d, err = getDeployment(s.Deployment); err != nil {
+			return nil, err
+		}
+	}
+	pod, err := s.manifest()
+	if err != nil {
+		return nil, err
+	}
+	replicas := 1
+	if d.Spec.Replicas != nil {
+		replicas = *d.Spec.Replicas
+	}
+	state, err := json.Marshal(swapState{Replicas: replicas})
+	if err != nil {
+		return nil, err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]string{SwapAnnotation: string(state)}},
+		"spec":     map[string]int{"replicas": 0},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := kubectl(nil, "patch", "deployment", s.Deployment, "--type=merge", "-p", string(patch)); err != nil {
+		return nil, fmt.Errorf("Unable to scale down deploy/%s:\n%s", s.Deployment, err)
+	}
+	restore := func() error { return RestoreDeployment(s.Deployment) }
+
+	_, err = kubectl(pod, "apply", "-f", "-")
+	if err == nil {
+		_, err = kubectl(nil, "wait", "--for=condition=Ready", "--timeout=2m", "pod/"+s.Pod)
+	}
+	if err != nil {
+		if err := restore(); err != nil {
+			log.Print(err)
+		}
+		return nil, fmt.Errorf("Unable to start pod/%s:\n%s", s.Pod, err)
+	}
+	return restore, nil
+}
+
+// manifest returns the pod to start.
+func (s *Swap) manifest() ([]byte, error) {
+	image, err := innerImage(s.Image)
+	if err != nil {
+		return nil, err
+	}
+	ports := append(s.containerPorts, containerPort{Name: "periscope-grpc", ContainerPort: s.GRPCPort})
+	return json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]interface{}{
+			"name":   s.Pod,
+			"labels": s.labels,
+			// With the Deployment's labels, an orphan pod would be adopted
+			// by its ReplicaSet, which would then delete it as one over its
+			// zero replicas. ReplicaSets only adopt pods with no controller.
+			"ownerReferences": []interface{}{map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"name":       s.Deployment,
+				"uid":        s.uid,
+				"controller": true,
+			}},
+		},
+		"spec": map[string]interface{}{
+			"serviceAccountName": s.serviceAccount,
+			"containers": []interface{}{map[string]interface{}{
+				"name":  "proxy",
+				"image": image,
+				"args":  []string{"-p", fmt.Sprint(s.HTTPPort), "-s", fmt.Sprint(s.GRPCPort)},
+				"ports": ports,
+			}},
+			"terminationGracePeriodSeconds": 10,
+		},
+	})
+}
+
+// RestoreDeployment scales the Deployment name back to the replicas kept in
+// its SwapAnnotation, if it has one, and deletes the pod started in its
+// place.
+func RestoreDeployment(name string) error {
+	d, err := getDeployment(name)
+	if err != nil {
+		return err
+	}
+	if saved, ok := d.Metadata.Annotations[SwapAnnotation]; ok {
+		var state swapState
+		if err := json.Unmarshal([]byte(saved), &state); err != nil {
+			return fmt.Errorf("Unable to parse %s of deploy/%s: %w", SwapAnnotation, name, err)
+		}
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{"annotations": map[string]interface{}{SwapAnnotation: nil}},
+			"spec":     map[string]int{"replicas": state.Replicas},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := kubectl(nil, "patch", "deployment", name, "--type=merge", "-p", string(patch)); err != nil {
+			return fmt.Errorf("Unable to restore deploy/%s:\n%s", name, err)
+		}
+		log.Printf("Restored deploy/%s to %d replicas", name, state.Replicas)
+	}
+	if _, err := kubectl(nil, "delete", "pod", swapPod(name), "--ignore-not-found", "--wait=false"); err != nil {
+		return fmt.Errorf("Unable to delete pod/%s:\n%s", swapPod(name), err)
+	}
+	return nil
+}
+
+func swapPod(deployment string) string {
+	return "periscope-swap-" + deployment
+}
+
+func getDeployment(name string) (*deployment, error) {
+	out, err := kubectl(nil, "get", "deployment", name, "-o", "json")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get deploy/%s:\n%s", name, err)
+	}
+	d := &deployment{}
+	if err := json.Unmarshal(out, d); err != nil {
+		return nil, fmt.Errorf("Unable to parse deploy/%s: %w", name, err)
+	}
+	return d, nil
+}
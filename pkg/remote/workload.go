@@ -0,0 +1,340 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RootEnv names the directory an imported workload's volumes are written
+// under, in the environment of the command run with them.
+const RootEnv = "PERISCOPE_ROOT"
+
+type keyRef struct {
+	Name     string `json:"name"`
+	Key      string `json:"key"`
+	Optional bool   `json:"optional"`
+}
+
+type envVar struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	ValueFrom *struct {
+		ConfigMapKeyRef  *keyRef         `json:"configMapKeyRef"`
+		SecretKeyRef     *keyRef         `json:"secretKeyRef"`
+		FieldRef         json.RawMessage `json:"fieldRef"`
+		ResourceFieldRef json.RawMessage `json:"resourceFieldRef"`
+	} `json:"valueFrom"`
+}
+
+type envFromSource struct {
+	Prefix       string  `json:"prefix"`
+	ConfigMapRef *keyRef `json:"configMapRef"`
+	SecretRef    *keyRef `json:"secretRef"`
+}
+
+type keyToPath struct {
+	Key  string `json:"key"`
+	Path string `json:"path"`
+}
+
+type volume struct {
+	Name      string `json:"name"`
+	ConfigMap *struct {
+		Name     string      `json:"name"`
+		Items    []keyToPath `json:"items"`
+		Optional bool        `json:"optional"`
+	} `json:"configMap"`
+	Secret *struct {
+		SecretName string      `json:"secretName"`
+		Items      []keyToPath `json:"items"`
+		Optional   bool        `json:"optional"`
+	} `json:"secret"`
+}
+
+type container struct {
+	Name         string          `json:"name"`
+	Env          []envVar        `json:"env"`
+	EnvFrom      []envFromSource `json:"envFrom"`
+	VolumeMounts []struct {
+		Name      string `json:"name"`
+		MountPath string `json:"mountPath"`
+		SubPath   string `json:"subPath"`
+	} `json:"volumeMounts"`
+}
+
+type podTemplate struct {
+	Spec struct {
+		Template struct {
+			Spec struct {
+				Containers []container `json:"containers"`
+				Volumes    []volume    `json:"volumes"`
+			} `json:"spec"`
+		} `json:"template"`
+	} `json:"spec"`
+}
+
+// ImportWorkload resolves the environment of a container (or the first, if
+// containerName is "") of the Deployment name, including ConfigMaps and
+// Secrets it refers to, and writes its ConfigMap and Secret volumes under
+// dir at their mount paths. It returns the environment, as NAME=value, with
+// RootEnv set to dir.
+func ImportWorkload(name string, containerName string, dir string) ([]string, error) {
+	out, err := kubectl(nil, "get", "deployment", name, "-o", "json")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get deploy/%s:\n%s", name, err)
+	}
+	var d podTemplate
+	if err := json.Unmarshal(out, &d); err != nil {
+		return nil, fmt.Errorf("Unable to parse deploy/%s: %w", name, err)
+	}
+	spec := d.Spec.Template.Spec
+	var c *container
+	for i := range spec.Containers {
+		if containerName == "" || spec.Containers[i].Name == containerName {
+			c = &spec.Containers[i]
+			break
+		}
+	}
+	if c == nil {
+		return nil, fmt.Errorf("deploy/%s has no container %q", name, containerName)
+	}
+
+	data := &objectData{configMaps: map[string]map[string][]byte{}, secrets: map[string]map[string][]byte{}}
+	var env []string
+	values := map[string]string{}
+	set := func(k, v string) {
+		values[k] = v
+		env = append(env, k+"="+v)
+	}
+	for _, from := range c.EnvFrom {
+		var kv map[string][]byte
+		switch {
+		case from.ConfigMapRef != nil:
+			kv, err = data.configMap(from.ConfigMapRef.Name, from.ConfigMapRef.Optional)
+		case from.SecretRef != nil:
+			kv, err = data.secret(from.SecretRef.Name, from.SecretRef.Optional)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range kv {
+			set(from.Prefix+k, string(v))
+		}
+	}
+	for _, e := range c.Env {
+		if e.ValueFrom == nil {
+			set(e.Name, expandRefs(e.Value, values))
+			continue
+		}
+		var ref *keyRef
+		var kv map[string][]byte
+		switch {
+		case e.ValueFrom.ConfigMapKeyRef != nil:
+			ref = e.ValueFrom.ConfigMapKeyRef
+			kv, err = data.configMap(ref.Name, ref.Optional)
+		case e.ValueFrom.SecretKeyRef != nil:
+			ref = e.ValueFrom.SecretKeyRef
+			kv, err = data.secret(ref.Name, ref.Optional)
+		default:
+			log.Printf("Not importing %s, which comes from the pod itself", e.Name)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		v, ok := kv[ref.Key]
+		if !ok && !ref.Optional {
+			return nil, fmt.Errorf("%s refers to missing key %q of %q", e.Name, ref.Key, ref.Name)
+		}
+		if ok {
+			set(e.Name, string(v))
+		}
+	}
+
+	volumes := map[string]volume{}
+	for _, v := range spec.Volumes {
+		volumes[v.Name] = v
+	}
+	for _, m := range c.VolumeMounts {
+		v := volumes[m.Name]
+		var kv map[string][]byte
+		var items []keyToPath
+		switch {
+		case v.ConfigMap != nil:
+			kv, err = data.configMap(v.ConfigMap.Name, v.ConfigMap.Optional)
+			items = v.ConfigMap.Items
+		case v.Secret != nil:
+			kv, err = data.secret(v.Secret.SecretName, v.Secret.Optional)
+			items = v.Secret.Items
+		default:
+			log.Printf("Not importing volume %q at %s, which isn't a ConfigMap or Secret", m.Name, m.MountPath)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := writeVolume(filepath.Join(dir, m.MountPath), kv, items, m.SubPath); err != nil {
+			return nil, err
+		}
+		log.Printf("Imported volume %q at $%s%s", m.Name, RootEnv, m.MountPath)
+	}
+	return append(env, RootEnv+"="+dir), nil
+}
+
+// writeVolume writes the files of a ConfigMap or Secret volume with data to
+// path, as the kubelet would mount it.
+func writeVolume(path string, data map[string][]byte, items []keyToPath, subPath string) error {
+	files := map[string][]byte{}
+	if len(items) == 0 {
+		for k, v := range data {
+			files[k] = v
+		}
+	}
+	for _, item := range items {
+		files[item.Path] = data[item.Key]
+	}
+	if subPath != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		return os.WriteFile(path, files[subPath], 0600)
+	}
+	for name, v := range files {
+		file := filepath.Join(path, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, v, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandRefs expands $(NAME) in value to earlier variables, as Kubernetes
+// does. $$ escapes a $, and references to unknown variables are left as
+// they are.
+func expandRefs(value string, values map[string]string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i+1 == len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+		switch next := value[i+1]; {
+		case next == '$':
+			b.WriteByte('$')
+			i++
+		case next == '(':
+			end := strings.IndexByte(value[i:], ')')
+			if end < 0 {
+				b.WriteByte('$')
+				continue
+			}
+			ref := value[i+2 : i+end]
+			if v, ok := values[ref]; ok {
+				b.WriteString(v)
+			} else {
+				b.WriteString(value[i : i+end+1])
+			}
+			i += end
+		default:
+			b.WriteByte('$')
+		}
+	}
+	return b.String()
+}
+
+// objectData fetches (once each) the data of ConfigMaps and Secrets.
+type objectData struct {
+	configMaps map[string]map[string][]byte
+	secrets    map[string]map[string][]byte
+}
+
+func (o *objectData) configMap(name string, optional bool) (map[string][]byte, error) {
+	if kv, ok := o.configMaps[name]; ok {
+		return kv, nil
+	}
+	var cm struct {
+		Data       map[string]string `json:"data"`
+		BinaryData map[string]string `json:"binaryData"`
+	}
+	if err := getObject("configmap", name, optional, &cm); err != nil {
+		return nil, err
+	}
+	kv := map[string][]byte{}
+	for k, v := range cm.Data {
+		kv[k] = []byte(v)
+	}
+	for k, v := range cm.BinaryData {
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode key %q of configmap/%s: %w", k, name, err)
+		}
+		kv[k] = b
+	}
+	o.configMaps[name] = kv
+	return kv, nil
+}
+
+func (o *objectData) secret(name string, optional bool) (map[string][]byte, error) {
+	if kv, ok := o.secrets[name]; ok {
+		return kv, nil
+	}
+	var s struct {
+		Data map[string]string `json:"data"`
+	}
+	if err := getObject("secret", name, optional, &s); err != nil {
+		return nil, err
+	}
+	kv := map[string][]byte{}
+	for k, v := range s.Data {
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to decode key %q of secret/%s: %w", k, name, err)
+		}
+		kv[k] = b
+	}
+	o.secrets[name] = kv
+	return kv, nil
+}
+
+// getObject decodes the object kind/name into v. A missing optional object
+// leaves v empty.
+func getObject(kind string, name string, optional bool, v interface{}) error {
+	out, err := kubectl(nil, "get", kind, name, "-o", "json", "--ignore-not-found")
+	if err != nil {
+		return fmt.Errorf("Unable to get %s/%s:\n%s", kind, name, err)
+	}
+	if len(strings.TrimSpace(string(out))) == 0 {
+		if optional {
+			return nil
+		}
+		return fmt.Errorf("%s/%s doesn't exist", kind, name)
+	}
+	if err := json.Unmarshal(out, v); err != nil {
+		return fmt.Errorf("Unable to parse %s/%s: %w", kind, name, err)
+	}
+	return nil
+}
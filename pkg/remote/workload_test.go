@@ -0,0 +1,109 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remote
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExpandRefs(t *testing.T) {
+	values := map[string]string{"HOST": "db", "PORT": "5432", "EMPTY": ""}
+	tests := []struct {
+		value, want string
+	}{
+		{"plain", "plain"},
+		{"$(HOST):$(PORT)", "db:5432"},
+		{"postgres://$(HOST)/orders", "postgres://db/orders"},
+		{"[$(EMPTY)]", "[]"},
+		{"$(MISSING)", "$(MISSING)"},
+		{"$$(HOST)", "$(HOST)"},
+		{"$$$(HOST)", "$db"},
+		{"cost: $5", "cost: $5"},
+		{"trailing $", "trailing $"},
+		{"$(HOST", "$(HOST"},
+		{"$()", "$()"},
+	}
+	for _, tt := range tests {
+		if got := expandRefs(tt.value, values); got != tt.want {
+			t.Errorf("expandRefs(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestWriteVolume(t *testing.T) {
+	data := map[string][]byte{"app.yaml": []byte("port: 80"), "log.level": []byte("debug")}
+	tests := []struct {
+		name    string
+		items   []keyToPath
+		subPath string
+		want    map[string]string
+	}{
+		{
+			name: "every key",
+			want: map[string]string{"app.yaml": "port: 80", "log.level": "debug"},
+		},
+		{
+			name:  "items",
+			items: []keyToPath{{Key: "app.yaml", Path: "conf/app.yaml"}},
+			want:  map[string]string{"conf/app.yaml": "port: 80"},
+		},
+		{
+			name:    "subPath",
+			subPath: "log.level",
+			want:    map[string]string{"": "debug"},
+		},
+		{
+			name:    "subPath of an item",
+			items:   []keyToPath{{Key: "app.yaml", Path: "settings"}},
+			subPath: "settings",
+			want:    map[string]string{"": "port: 80"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "etc", "config")
+			if err := writeVolume(path, data, tt.items, tt.subPath); err != nil {
+				t.Fatalf("writeVolume: %v", err)
+			}
+			got := map[string]string{}
+			err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+				if err != nil || info.IsDir() {
+					return err
+				}
+				rel, err := filepath.Rel(path, file)
+				if err != nil {
+					return err
+				}
+				if rel == "." {
+					rel = ""
+				}
+				contents, err := os.ReadFile(file)
+				got[filepath.ToSlash(rel)] = string(contents)
+				return err
+			})
+			if err != nil {
+				t.Fatalf("Reading the volume: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Wrote %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
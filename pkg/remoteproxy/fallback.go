@@ -0,0 +1,47 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remoteproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/evankanderson/periscope/pkg/periscope"
+)
+
+// newFallback returns a handler which proxies requests to upstream, given as
+// a URL or host:port.
+func newFallback(upstream string) (http.Handler, error) {
+	if !strings.Contains(upstream, "://") {
+		upstream = "http://" + upstream
+	}
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse fallback %q: %w", upstream, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("Expected a host in fallback %q", upstream)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	proxy.Transport = periscope.NewTransport(nil, http.ProxyFromEnvironment)
+	// Pass streamed responses (e.g. long-polls) on as they arrive.
+	proxy.FlushInterval = -1
+	return proxy, nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remoteproxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/evankanderson/periscope/pkg/periscope"
+)
+
+// newUpstream starts a server standing in for a Service in the cluster,
+// which answers with its name and the path.
+func newUpstream(t *testing.T, name string) *httptest.Server {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", name, r.URL.Path)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func TestFallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		session string
+		want    string
+	}{
+		{name: "healthy session", session: "", want: "laptop /x"},
+		{name: "no session", session: "nobody", want: "service /x"},
+		{name: "unhealthy session", session: "sick", want: "service /x"},
+		{name: "session's own fallback", session: "alice", want: "alice-service /x"},
+		{name: "healthy session with its own fallback", session: "bob", want: "laptop /x"},
+	}
+	p := newTestProxy(t, Config{Fallback: newUpstream(t, "service").URL}, nil)
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := p.SetRules(context.Background(), &periscope.Rules{Session: name, Fallback: newUpstream(t, name+"-service").URL}); err != nil {
+			t.Fatalf("SetRules(%s): %v", name, err)
+		}
+	}
+	p.connect(t, "", respond(http.StatusOK, "laptop /x"))
+	p.connect(t, "bob", respond(http.StatusOK, "laptop /x"))
+	sick, _ := p.connect(t, "sick", respond(http.StatusOK, "laptop /x"))
+	if err := sick.ReportHealth(false, "target down"); err != nil {
+		t.Fatalf("ReportHealth: %v", err)
+	}
+	eventually(t, "the session to be unhealthy", func() bool {
+		p.lock.Lock()
+		session := p.sessions["sick"]
+		p.lock.Unlock()
+		healthy, _ := session.Healthy()
+		return !healthy
+	})
+
+	for _, tt := range tests {
+		status, body := p.get(t, "/x", http.Header{SessionHeader: {tt.session}})
+		if status != http.StatusOK || body != tt.want {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, status, body, http.StatusOK, tt.want)
+		}
+	}
+}
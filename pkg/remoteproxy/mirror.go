@@ -0,0 +1,222 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remoteproxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/evankanderson/periscope/pkg/periscope"
+)
+
+const (
+	// mirrorBodyLimit is the largest request body kept to mirror; larger
+	// requests are answered but not mirrored. Larger response bodies are
+	// mirrored without the fallback's.
+	mirrorBodyLimit = 1 << 20
+	// mirrorQueueSize is how many copies may wait to be sent to sessions;
+	// more are dropped rather than slow the callers.
+	mirrorQueueSize = 64
+	// mirrorWorkers is how many copies are sent at once.
+	mirrorWorkers = 4
+	// mirrorTimeout is how long a session has to answer a copy.
+	mirrorTimeout = 30 * time.Second
+)
+
+// A mirrorCopy is a request answered by the fallback, to send to a session.
+type mirrorCopy struct {
+	session *periscope.Mux
+	request *periscope.ProxyRequest
+	body    []byte
+	trailer http.Header
+}
+
+// serveMirror answers r from fallback, then queues a copy of it for the
+// session named name, if that is connected, along with the answer if the
+// session's rules ask to shadow.
+func (s *LocalProxy) serveMirror(w http.ResponseWriter, r *http.Request, name string, send *periscope.ProxyRequest, fallback http.Handler) {
+	log.Printf("REV MIRROR: %s", r.URL)
+	if periscope.IsUpgrade(r.Header) {
+		fallback.ServeHTTP(w, r)
+		return
+	}
+	var body *mirrorBody
+	if r.Body != nil && r.Body != http.NoBody {
+		body = &mirrorBody{ReadCloser: r.Body}
+		r.Body = body
+	}
+	s.lock.Lock()
+	shadow := s.rules[name] != nil && s.rules[name].Shadow
+	s.lock.Unlock()
+	if shadow {
+		answer := &answerRecorder{ResponseWriter: w}
+		fallback.ServeHTTP(answer, r)
+		send.Answer = answer.answer()
+	} else {
+		fallback.ServeHTTP(w, r)
+	}
+	s.lock.Lock()
+	session := s.sessions[name]
+	s.lock.Unlock()
+	if session == nil {
+		return
+	}
+	c := &mirrorCopy{session: session, request: send, trailer: r.Trailer}
+	if body != nil {
+		data, ok := body.contents()
+		if !ok {
+			log.Printf("MIRROR: %s: not mirrored, body too large or unread", r.URL)
+			return
+		}
+		c.body = data
+	}
+	select {
+	case s.mirrors <- c:
+	default:
+		log.Printf("MIRROR: %s: dropped, %d copies already waiting", r.URL, mirrorQueueSize)
+	}
+}
+
+// mirrorCopies sends queued copies to their sessions until the proxy exits.
+func (s *LocalProxy) mirrorCopies() {
+	for c := range s.mirrors {
+		mirror(c)
+	}
+}
+
+// mirror sends c to its session and logs the response, which is discarded.
+func mirror(c *mirrorCopy) {
+	stream, err := c.session.Open(c.request)
+	if err != nil {
+		log.Printf("MIRROR: %s: %s", c.request.Target, err)
+		return
+	}
+	defer stream.Cancel()
+	// Don't let a stuck session hold up the other copies.
+	timer := time.AfterFunc(mirrorTimeout, stream.Cancel)
+	defer timer.Stop()
+	go stream.SendBody(bytes.NewReader(c.body), func() http.Header { return c.trailer })
+	out, err := stream.Response()
+	if err != nil {
+		log.Printf("MIRROR %d: %s", stream.ID(), err)
+		return
+	}
+	n, err := io.Copy(io.Discard, periscope.NewBodyReader(stream.Recv, nil, nil))
+	if err != nil {
+		log.Printf("MIRROR %d: %d %s, body failed after %d bytes: %s", stream.ID(), out.Status, c.request.Target, n, err)
+		return
+	}
+	log.Printf("MIRROR %d: %d %s (%d bytes)", stream.ID(), out.Status, c.request.Target, n)
+}
+
+// answerRecorder keeps a copy of the response written to it, with up to
+// mirrorBodyLimit bytes of its body.
+type answerRecorder struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (a *answerRecorder) WriteHeader(status int) {
+	if a.status == 0 {
+		a.status = status
+		a.header = a.Header().Clone()
+	}
+	a.ResponseWriter.WriteHeader(status)
+}
+
+func (a *answerRecorder) Write(p []byte) (int, error) {
+	if a.status == 0 {
+		a.WriteHeader(http.StatusOK)
+	}
+	if !a.overflow {
+		if a.body.Len()+len(p) > mirrorBodyLimit {
+			a.overflow = true
+			a.body = bytes.Buffer{}
+		} else {
+			a.body.Write(p)
+		}
+	}
+	return a.ResponseWriter.Write(p)
+}
+
+func (a *answerRecorder) Flush() {
+	if f, ok := a.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// answer returns the recorded response.
+func (a *answerRecorder) answer() *periscope.Answer {
+	status := a.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return &periscope.Answer{
+		Response: &periscope.ProxyResponse{
+			Status:  int32(status),
+			Reason:  fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			Headers: periscope.HeadersToProto(a.header),
+		},
+		Body:      a.body.Bytes(),
+		Truncated: a.overflow,
+	}
+}
+
+// mirrorBody keeps a copy of up to mirrorBodyLimit bytes of a request body
+// as the fallback reads it. The transport may still be reading it when the
+// fallback returns, so it is guarded by lock.
+type mirrorBody struct {
+	io.ReadCloser
+	lock     sync.Mutex
+	buf      bytes.Buffer
+	overflow bool
+	done     bool
+}
+
+func (b *mirrorBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.overflow {
+		if b.buf.Len()+n > mirrorBodyLimit {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		b.done = true
+	}
+	return n, err
+}
+
+// contents returns the whole body, or false if it was too large or hasn't
+// been read to the end.
+func (b *mirrorBody) contents() ([]byte, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Bytes(), b.done && !b.overflow
+}
@@ -25,36 +25,114 @@ import (
 	"net"
 	"net/http"
 	"sync"
+	"time"
 
 	"github.com/evankanderson/periscope/pkg/periscope"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// Config describes what an inner proxy serves.
+type Config struct {
+	// The port for HTTP requests out of the cluster.
+	HTTPPort int
+	// The port for the GRPC service the outside proxy connects to.
+	GRPCPort int
+	// How long a request out of the cluster waits for the developer's
+	// machine to start answering before failing with a 504. Zero waits as
+	// long as the caller does.
+	Timeout time.Duration
+	// How long a request out of the cluster waits for a session to attach
+	// (e.g. while the developer's machine reconnects) before failing with a
+	// 502. Zero fails it right away.
+	Grace time.Duration
+	// The most requests which may wait for a session at once; more fail
+	// with a 503. Zero means no limit.
+	QueueSize int
+	// The session requests which don't name one are sent to. Unnamed
+	// sessions are "".
+	DefaultSession string
+	// If set, the URL (or host:port) of the upstream, such as the Service
+	// being intercepted, to send requests out of the cluster to while no
+	// session is connected or its target is unhealthy. Sessions may name
+	// their own in their rules.
+	Fallback string
+}
+
 type LocalProxy struct {
 	periscope.UnimplementedPeriscopeServer
 	httpServer *http.Server
-	stream     periscope.Periscope_OutServer
+	timeout    time.Duration
+	grace      time.Duration
+	queueSize  int
+	// Serves requests no session can, if set, for sessions which don't name
+	// their own.
+	fallback http.Handler
+	// The Sessions of the connected outside proxies by name, which requests
+	// out of the cluster are sent over. Guarded by lock.
+	sessions       map[string]*periscope.Mux
+	defaultSession string
+	// The rules of each session which has any. Guarded by lock.
+	rules map[string]*periscope.Rules
+	// The fallbacks named by sessions' rules. Guarded by lock.
+	fallbacks map[string]http.Handler
+	// Copies of requests waiting to be mirrored to a session.
+	mirrors chan *mirrorCopy
+	// Used to send requests from the Session into the cluster.
+	client *http.Client
 
 	grpcAddr string
 
-	// This contains the set of outstanding locally-proxied requests awaiting
-	// responses over the (singular) grpc stream.
-	awaiting map[int64]chan *periscope.ProxyResponse
-	lock     sync.Mutex
+	lock sync.Mutex
+	// Closed and replaced whenever any session attaches, to wake requests
+	// waiting for one. Guarded by lock.
+	attached chan struct{}
+	// How many requests are waiting for a session. Guarded by lock.
+	waiting int
+
+	// Raw connections announced on the Session, waiting to be picked up by
+	// Accept. Also guarded by lock.
+	unclaimed map[int64]net.Conn
 }
 
-func NewLocalProxy(httpPort int, grpcPort int) (*LocalProxy, error) {
+// acceptTimeout is how long an announced connection waits to be picked up
+// by Accept before it is closed.
+const acceptTimeout = 30 * time.Second
+
+func NewLocalProxy(cfg Config) (*LocalProxy, error) {
 
 	ret := LocalProxy{
 		httpServer: &http.Server{
-			Addr: fmt.Sprintf(":%d", httpPort),
+			Addr: fmt.Sprintf(":%d", cfg.HTTPPort),
 		},
-		grpcAddr: fmt.Sprintf(":%d", grpcPort),
+		sessions:       make(map[string]*periscope.Mux),
+		defaultSession: cfg.DefaultSession,
+		rules:          make(map[string]*periscope.Rules),
+		fallbacks:      make(map[string]http.Handler),
+		mirrors:        make(chan *mirrorCopy, mirrorQueueSize),
+		timeout:        cfg.Timeout,
+		grace:          cfg.Grace,
+		queueSize:      cfg.QueueSize,
+		grpcAddr:       fmt.Sprintf(":%d", cfg.GRPCPort),
+		client:         &http.Client{Transport: periscope.NewTransport(nil, http.ProxyFromEnvironment)},
 
-		awaiting: make(map[int64]chan *periscope.ProxyResponse),
-		lock:     sync.Mutex{},
+		lock:      sync.Mutex{},
+		attached:  make(chan struct{}),
+		unclaimed: make(map[int64]net.Conn),
+	}
+	if cfg.Fallback != "" {
+		fallback, err := newFallback(cfg.Fallback)
+		if err != nil {
+			return nil, err
+		}
+		ret.fallback = fallback
+	}
+	for i := 0; i < mirrorWorkers; i++ {
+		go ret.mirrorCopies()
 	}
-	ret.httpServer.Handler = &ret
+	ret.httpServer.Handler = periscope.H2CHandler(&ret)
 	return &ret, nil
 }
 
@@ -73,78 +151,281 @@ func (s *LocalProxy) Start() error {
 	return nil
 }
 
-func (s *LocalProxy) In(ctx context.Context, in *periscope.ProxyRequest) (*periscope.ProxyResponse, error) {
-	req, err := periscope.ReqToHttp(in)
-	log.Printf("IN: %s", req.URL)
-	resp, err := http.DefaultClient.Do(req)
+func (s *LocalProxy) Hello(ctx context.Context, in *periscope.Handshake) (*periscope.Handshake, error) {
+	caps, err := periscope.Negotiate(in)
 	if err != nil {
-		return nil, err
+		log.Printf("HELLO: %s", err)
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
 	}
-	return periscope.HttpToResp(*resp)
+	log.Printf("HELLO: periscope %s (protocol %d) with %s", in.Version, in.Protocol, periscope.FormatCapabilities(caps))
+	return periscope.NewHandshake(), nil
 }
 
-func (s *LocalProxy) Out(stream periscope.Periscope_OutServer) error {
-	func() {
-		s.lock.Lock()
-		defer s.lock.Unlock()
-		s.stream = stream
-	}()
-	defer func() {
-		s.lock.Lock()
-		defer s.lock.Unlock()
-		s.stream = nil
-	}()
+func (s *LocalProxy) Session(stream periscope.Periscope_SessionServer) error {
+	name := ""
+	if md, ok := metadata.FromIncomingContext(stream.Context()); ok {
+		if names := md.Get(periscope.SessionMetadata); len(names) > 0 {
+			name = names[0]
+		}
+	}
+	session := periscope.NewMux(stream, false, s.serveIn)
+	s.attach(name, session)
+	defer s.detach(name, session)
+	log.Printf("SESSION %q: started", name)
+	err := session.Run()
+	log.Printf("SESSION %q: ended: %v", name, err)
+	return err
+}
+
+// serveIn proxies a request from the outside proxy into the cluster.
+func (s *LocalProxy) serveIn(stream *periscope.Stream) {
+	req, err := periscope.ReqToHttp(stream.Request())
+	if err != nil {
+		stream.RespondError("Failed decode", err)
+		return
+	}
+	req.Body = periscope.NewBodyReader(stream.Recv, nil, nil)
+	// Abandon the request if the outside proxy cancels it.
+	req = req.WithContext(stream.Context())
+	log.Printf("IN %d: %s", stream.ID(), req.URL)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		log.Printf("IN %d: %s", stream.ID(), err)
+		stream.RespondError("Failed request", err)
+		return
+	}
+	defer resp.Body.Close()
+	if err := stream.Respond(periscope.HttpToResp(resp)); err != nil {
+		return
+	}
+	stream.SendBody(resp.Body, func() http.Header { return resp.Trailer })
+}
 
+func (s *LocalProxy) Tunnel(stream periscope.Periscope_TunnelServer) error {
+	in, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	open := in.GetOpen()
+	if open == nil {
+		return fmt.Errorf("Expected tunnel open, got %T", in.Kind)
+	}
+	log.Printf("TUNNEL: %s", open.Address)
+	conn, err := (&net.Dialer{}).DialContext(stream.Context(), "tcp", open.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := stream.Send(&periscope.TunnelData{Kind: &periscope.TunnelData_Open{
+		Open: &periscope.TunnelOpen{Address: conn.RemoteAddr().String()},
+	}}); err != nil {
+		return err
+	}
+	return periscope.Splice(conn, stream)
+}
+
+func (s *LocalProxy) Accept(stream periscope.Periscope_AcceptServer) error {
+	in, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	open := in.GetOpen()
+	if open == nil {
+		return fmt.Errorf("Expected tunnel open, got %T", in.Kind)
+	}
+	conn := s.claim(open.Id)
+	if conn == nil {
+		return fmt.Errorf("No connection waiting for %d", open.Id)
+	}
+	defer conn.Close()
+	if err := stream.Send(&periscope.TunnelData{Kind: &periscope.TunnelData_Open{
+		Open: &periscope.TunnelOpen{Address: conn.RemoteAddr().String(), Id: open.Id},
+	}}); err != nil {
+		return err
+	}
+	return periscope.Splice(conn, stream)
+}
+
+func (s *LocalProxy) Listen(in *periscope.ListenRequest, stream periscope.Periscope_ListenServer) error {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", in.Port))
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-stream.Context().Done()
+		lis.Close()
+	}()
+	log.Printf("LISTEN: %s", lis.Addr())
+	if err := stream.Send(&periscope.TunnelOpen{Address: lis.Addr().String()}); err != nil {
+		return err
+	}
 	for {
-		in, err := stream.Recv()
-		if err == io.EOF {
-			return nil
-		}
+		conn, err := lis.Accept()
 		if err != nil {
+			if stream.Context().Err() != nil {
+				return nil
+			}
 			return err
 		}
-
-		func() {
-			s.lock.Lock()
-			defer s.lock.Unlock()
-			if c := s.awaiting[in.Id]; c != nil {
-				c <- in
-				close(c)
-				delete(s.awaiting, in.Id)
-				log.Printf("REV DONE %d: %s", in.Id, in.Reason)
+		id := rand.Int63()
+		log.Printf("REV TCP %d: %s from %s", id, lis.Addr(), conn.RemoteAddr())
+		s.announce(id, conn)
+		if err := stream.Send(&periscope.TunnelOpen{Id: id, Address: conn.RemoteAddr().String()}); err != nil {
+			if conn := s.claim(id); conn != nil {
+				conn.Close()
 			}
-		}()
+			return err
+		}
 	}
 }
 
-func (s *LocalProxy) proxyRequest(in *periscope.ProxyRequest, stream periscope.Periscope_OutServer) {
-	return
+// announce makes conn available to Accept as id for acceptTimeout.
+func (s *LocalProxy) announce(id int64, conn net.Conn) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.unclaimed[id] = conn
+	time.AfterFunc(acceptTimeout, func() {
+		if conn := s.claim(id); conn != nil {
+			log.Printf("REV %d: not accepted", id)
+			conn.Close()
+		}
+	})
+}
+
+func (s *LocalProxy) claim(id int64) net.Conn {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	conn := s.unclaimed[id]
+	delete(s.unclaimed, id)
+	return conn
 }
 
 func (s *LocalProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	send, err := periscope.HttpToReq(*r)
+	send := periscope.HttpToReq(r)
+	name := s.sessionName(r)
+	fallback := s.fallbackFor(name)
+	switch s.route(name, r) {
+	case routeFallback:
+		log.Printf("REV UPSTREAM: %s", r.URL)
+		fallback.ServeHTTP(w, r)
+		return
+	case routeMirror:
+		s.serveMirror(w, r, name, send, fallback)
+		return
+	}
+	session, err := s.awaitSession(r.Context(), name)
+	if err == errQueueFull {
+		log.Printf("REV: %s: %s", r.URL, err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	if err != nil {
-		w.WriteHeader(500)
-		w.Write([]byte(err.Error()))
+		log.Printf("REV: %s: cancelled waiting for a session", r.URL)
 		return
 	}
-	send.Id = rand.Int63()
-	c := make(chan (*periscope.ProxyResponse), 1)
-	var stream periscope.Periscope_OutServer
-	func() {
-		s.lock.Lock()
-		defer s.lock.Unlock()
-		s.awaiting[send.Id] = c
-		stream = s.stream
+	if fallback != nil {
+		reason := fmt.Sprintf("no session %q", name)
+		healthy := session != nil
+		if healthy {
+			healthy, reason = session.Healthy()
+		}
+		if !healthy {
+			log.Printf("REV FALLBACK: %s: %s", r.URL, reason)
+			fallback.ServeHTTP(w, r)
+			return
+		}
+	}
+	if session == nil {
+		log.Printf("REV: %s: no session %q", r.URL, name)
+		message := "No periscope session is connected"
+		if name != "" {
+			message = fmt.Sprintf("No periscope session %q is connected", name)
+		}
+		http.Error(w, message, http.StatusBadGateway)
+		return
+	}
+	if periscope.IsUpgrade(r.Header) {
+		s.serveUpgrade(w, r, session, send)
+		return
+	}
+	stream, err := session.Open(send)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	stream.Watch(r.Context())
+	log.Printf("REV %d: %s", stream.ID(), r.URL)
+	bodySent := make(chan struct{})
+	go func() {
+		defer close(bodySent)
+		stream.SendBody(r.Body, func() http.Header { return r.Trailer })
+	}()
+	// Unless the response arrives whole, tell the outside proxy to give up
+	// on the request, e.g. because the caller hung up or timed out. That
+	// stops SendBody after its current read; net/http doesn't allow reading
+	// r.Body once this returns (and can't answer until that read does).
+	defer func() {
+		stream.Cancel()
+		<-bodySent
 	}()
-	log.Printf("REV %d: %s", send.Id, r.URL)
-	stream.Send(send)
-
-	out := <-c
 
-	for k, v := range out.Headers {
-		w.Header().Set(k, v)
+	// Give up if the developer's machine doesn't start answering in time.
+	var timer *time.Timer
+	if s.timeout > 0 {
+		timer = time.AfterFunc(s.timeout, stream.Cancel)
+	}
+	out, err := stream.Response()
+	timedOut := timer != nil && !timer.Stop()
+	if err != nil {
+		switch {
+		case r.Context().Err() != nil:
+			log.Printf("REV %d: cancelled", stream.ID())
+		case timedOut:
+			log.Printf("REV %d: timed out after %s", stream.ID(), s.timeout)
+			http.Error(w, fmt.Sprintf("No response from periscope session within %s", s.timeout), http.StatusGatewayTimeout)
+		default:
+			log.Printf("REV %d: %s", stream.ID(), err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+	for k, v := range periscope.HeadersFromProto(out.Headers) {
+		w.Header()[k] = v
 	}
 	w.WriteHeader(int(out.Status))
-	w.Write(out.Body)
+	trailer := make(http.Header)
+	body := periscope.NewBodyReader(stream.Recv, trailer, nil)
+	if _, err := io.Copy(periscope.FlushingResponseWriter{ResponseWriter: w}, body); err != nil {
+		log.Printf("REV %d: %s", stream.ID(), err)
+		// Break the connection rather than let the caller take a truncated
+		// body for a whole one.
+		panic(http.ErrAbortHandler)
+	}
+	periscope.WriteTrailers(w, trailer)
+	log.Printf("REV DONE %d", stream.ID())
+}
+
+// serveUpgrade hands the connection for a request which switches protocols
+// to the outside proxy to relay raw.
+func (s *LocalProxy) serveUpgrade(w http.ResponseWriter, r *http.Request, session *periscope.Mux, send *periscope.ProxyRequest) {
+	send.Id = rand.Int63()
+	send.Upgrade = true
+	conn, buffered, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("REV UPGRADE %d: %s", send.Id, r.URL)
+	s.announce(send.Id, periscope.WithBuffered(conn, buffered.Reader))
+	stream, err := session.Open(send)
+	if err != nil {
+		log.Printf("REV UPGRADE %d: %s", send.Id, err)
+		if conn := s.claim(send.Id); conn != nil {
+			io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+			conn.Close()
+		}
+		return
+	}
+	// The connection follows over Accept, not the stream.
+	stream.Close()
 }
@@ -0,0 +1,258 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remoteproxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evankanderson/periscope/pkg/periscope"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// testProxy is an inner proxy with its GRPC service on grpcAddr and its
+// HTTP proxy served by http.
+type testProxy struct {
+	*LocalProxy
+	grpcAddr string
+	http     *httptest.Server
+}
+
+// newTestProxy starts an inner proxy configured by cfg, whose HTTP requests
+// pass through wrap, if set.
+func newTestProxy(t *testing.T, cfg Config, wrap func(http.Handler) http.Handler) *testProxy {
+	t.Helper()
+	s, err := NewLocalProxy(cfg)
+	if err != nil {
+		t.Fatalf("NewLocalProxy: %v", err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	server := grpc.NewServer()
+	periscope.RegisterPeriscopeServer(server, s)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	var handler http.Handler = s
+	if wrap != nil {
+		handler = wrap(s)
+	}
+	p := &testProxy{LocalProxy: s, grpcAddr: lis.Addr().String(), http: httptest.NewServer(handler)}
+	t.Cleanup(p.http.Close)
+	return p
+}
+
+// connect attaches a session named name, as the outside proxy would, whose
+// requests are answered by handler. It stays attached until the returned
+// function is called, or the test ends.
+func (p *testProxy) connect(t *testing.T, name string, handler func(*periscope.Stream)) (*periscope.Mux, func()) {
+	t.Helper()
+	conn, err := grpc.Dial(p.grpcAddr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	ctx, cancel := context.WithCancel(metadata.AppendToOutgoingContext(context.Background(), periscope.SessionMetadata, name))
+	stream, err := periscope.NewPeriscopeClient(conn).Session(ctx)
+	if err != nil {
+		t.Fatalf("Session: %v", err)
+	}
+	session := periscope.NewMux(stream, true, handler)
+	go session.Run()
+	disconnect := func() {
+		cancel()
+		conn.Close()
+	}
+	t.Cleanup(disconnect)
+	eventually(t, "the session to attach", func() bool {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		return p.sessions[name] != nil
+	})
+	return session, disconnect
+}
+
+// eventually waits for cond to hold.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// get sends a request for path to p, returning the status and body of the
+// response.
+func (p *testProxy) get(t *testing.T, path string, header http.Header) (int, string) {
+	t.Helper()
+	status, body, err := p.fetch(path, header)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	return status, body
+}
+
+// fetch is get, for use outside the test's goroutine.
+func (p *testProxy) fetch(path string, header http.Header) (int, string, error) {
+	r, err := http.NewRequest(http.MethodGet, p.http.URL+path, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), err
+}
+
+// respond answers every request with status and body.
+func respond(status int, body string) func(*periscope.Stream) {
+	return func(st *periscope.Stream) {
+		io.Copy(io.Discard, periscope.NewBodyReader(st.Recv, nil, nil))
+		st.Respond(&periscope.ProxyResponse{Status: int32(status)})
+		st.SendBody(strings.NewReader(body), nil)
+	}
+}
+
+// handlerBody flags reads of a request body after the handler given it has
+// returned, which net/http doesn't allow.
+type handlerBody struct {
+	io.ReadCloser
+	t *testing.T
+	// Set once the handler has returned, deliberately without a lock, so
+	// that the race detector catches later reads too.
+	returned bool
+	// Receives once for each read.
+	reads chan struct{}
+}
+
+func (b *handlerBody) Read(p []byte) (int, error) {
+	if b.returned {
+		b.t.Error("Request body read after the handler returned")
+	}
+	n, err := b.ReadCloser.Read(p)
+	if b.returned {
+		b.t.Error("Request body read across the handler returning")
+	}
+	b.reads <- struct{}{}
+	return n, err
+}
+
+func TestServeHTTPBodyAfterTimeout(t *testing.T) {
+	body := &handlerBody{t: t, reads: make(chan struct{}, 16)}
+	p := newTestProxy(t, Config{Timeout: 50 * time.Millisecond}, func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body.ReadCloser = r.Body
+			r.Body = body
+			h.ServeHTTP(w, r)
+			body.returned = true
+		})
+	})
+	cancelled := make(chan struct{}, 1)
+	p.connect(t, "", func(st *periscope.Stream) {
+		<-st.Context().Done()
+		cancelled <- struct{}{}
+	})
+
+	// A caller which sends part of its body, then stalls.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.Post(p.http.URL+"/slow", "text/plain", pr)
+		if err != nil {
+			t.Errorf("POST: %v", err)
+		}
+		responses <- resp
+	}()
+	wait := func(c <-chan struct{}, what string) {
+		t.Helper()
+		select {
+		case <-c:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+	}
+	pw.Write([]byte("partial"))
+	wait(body.reads, "the start of the body")
+	wait(cancelled, "the request to time out")
+
+	// The rest of the body arrives after the proxy has given up.
+	pw.Write([]byte("rest"))
+	pw.Close()
+	wait(body.reads, "the rest of the body")
+	select {
+	case resp := <-responses:
+		if resp == nil {
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusGatewayTimeout {
+			t.Errorf("Got status %d for a stalled session, want %d", resp.StatusCode, http.StatusGatewayTimeout)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the response")
+	}
+}
+
+func TestServeHTTPTimeout(t *testing.T) {
+	p := newTestProxy(t, Config{Timeout: 50 * time.Millisecond}, nil)
+	p.connect(t, "", func(st *periscope.Stream) {
+		<-st.Context().Done()
+	})
+	status, body := p.get(t, "/stalled", nil)
+	if want := "No response from periscope session within 50ms\n"; status != http.StatusGatewayTimeout || body != want {
+		t.Errorf("Got %d %q from a stalled session, want %d %q", status, body, http.StatusGatewayTimeout, want)
+	}
+}
+
+func TestServeHTTPNoSession(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   string
+	}{
+		{name: "default", want: "No periscope session is connected\n"},
+		{name: "named", header: http.Header{SessionHeader: {"alice"}}, want: "No periscope session \"alice\" is connected\n"},
+	}
+	p := newTestProxy(t, Config{}, nil)
+	// Another session doesn't answer for them.
+	p.connect(t, "bob", func(st *periscope.Stream) {
+		st.RespondError("Wrong session", io.EOF)
+	})
+	for _, tt := range tests {
+		status, body := p.get(t, "/", tt.header)
+		if status != http.StatusBadGateway || body != tt.want {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, status, body, http.StatusBadGateway, tt.want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remoteproxy
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/evankanderson/periscope/pkg/periscope"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *LocalProxy) SetRules(ctx context.Context, in *periscope.Rules) (*periscope.Rules, error) {
+	fallback := s.fallback
+	if in.Fallback != "" {
+		f, err := newFallback(in.Fallback)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		fallback = f
+	}
+	if len(in.Rules) > 0 && fallback == nil {
+		return nil, status.Error(codes.FailedPrecondition, "No --fallback to send requests which don't match the rules to")
+	}
+	if in.Mirror && fallback == nil {
+		return nil, status.Error(codes.FailedPrecondition, "No --fallback to answer mirrored requests")
+	}
+	for _, r := range in.Rules {
+		if _, err := path.Match(r.Path, ""); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "Invalid path %q: %s", r.Path, err)
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(in.Rules) == 0 && !in.Mirror && in.Fallback == "" {
+		delete(s.rules, in.Session)
+	} else {
+		s.rules[in.Session] = in
+	}
+	if in.Fallback != "" {
+		s.fallbacks[in.Session] = fallback
+	} else {
+		delete(s.fallbacks, in.Session)
+	}
+	log.Printf("RULES %q: %d rules, mirror=%t, shadow=%t, fallback=%q", in.Session, len(in.Rules), in.Mirror, in.Shadow, in.Fallback)
+	return in, nil
+}
+
+func (s *LocalProxy) GetRules(ctx context.Context, in *periscope.Rules) (*periscope.Rules, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if rules := s.rules[in.Session]; rules != nil {
+		return rules, nil
+	}
+	return &periscope.Rules{Session: in.Session}, nil
+}
+
+// fallbackFor returns the fallback of the session named name, which may be
+// nil.
+func (s *LocalProxy) fallbackFor(name string) http.Handler {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if fallback := s.fallbacks[name]; fallback != nil {
+		return fallback
+	}
+	return s.fallback
+}
+
+// A route is where a request out of the cluster is sent.
+type route int
+
+const (
+	// To the session.
+	routeSession route = iota
+	// To the fallback only.
+	routeFallback
+	// To the fallback, with a copy to the session.
+	routeMirror
+)
+
+// route returns where the rules of the session named name (if any) send r.
+func (s *LocalProxy) route(name string, r *http.Request) route {
+	s.lock.Lock()
+	rules := s.rules[name]
+	s.lock.Unlock()
+	if rules == nil {
+		return routeSession
+	}
+	picked := len(rules.Rules) == 0
+	for _, rule := range rules.Rules {
+		if matchRule(rule, r) {
+			picked = true
+			break
+		}
+	}
+	switch {
+	case !picked:
+		return routeFallback
+	case rules.Mirror:
+		return routeMirror
+	}
+	return routeSession
+}
+
+func matchRule(rule *periscope.Rule, r *http.Request) bool {
+	if rule.Method != "" && !strings.EqualFold(rule.Method, r.Method) {
+		return false
+	}
+	if rule.Path != "" && !matchPath(rule.Path, r.URL.Path) {
+		return false
+	}
+	for _, h := range rule.Headers {
+		found := false
+		for _, v := range r.Header.Values(h.Name) {
+			if v == h.Value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if rule.Percent > 0 && rule.Percent < 100 && rand.Int31n(100) >= rule.Percent {
+		return false
+	}
+	return true
+}
+
+// matchPath matches p against pattern as path.Match does, except that a
+// trailing "*" in pattern also matches any further segments, so that
+// "/api/v2/*" matches "/api/v2/users/1".
+func matchPath(pattern string, p string) bool {
+	if ok, _ := path.Match(pattern, p); ok {
+		return true
+	}
+	if !strings.HasSuffix(pattern, "*") {
+		return false
+	}
+	// Match the pattern against each prefix of p ending in a segment.
+	for i := len(p); i > 0; i = strings.LastIndex(p[:i], "/") {
+		if ok, _ := path.Match(pattern, p[:i]); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remoteproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/evankanderson/periscope/pkg/periscope"
+)
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/api/v2/users", "/api/v2/users", true},
+		{"/api/v2/users", "/api/v2/users/1", false},
+		{"/api/v2/*", "/api/v2/users", true},
+		{"/api/v2/*", "/api/v2/users/1/orders", true},
+		{"/api/v2/*", "/api/v2/", true},
+		{"/api/v2/*", "/api/v2", false},
+		{"/api/v2/*", "/api/v3/users", false},
+		{"/api/*/users", "/api/v2/users", true},
+		{"/api/*/users", "/api/v2/users/1", false},
+		{"/api/v?/*", "/api/v1/x/y", true},
+		{"/*", "/", true},
+		{"/*", "/anything/at/all", true},
+		{"/[", "/[", false},
+	}
+	for _, tt := range tests {
+		if got := matchPath(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("matchPath(%q, %q) = %t, want %t", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestMatchRule(t *testing.T) {
+	tests := []struct {
+		name string
+		rule *periscope.Rule
+		want bool
+	}{
+		{name: "empty", rule: &periscope.Rule{}, want: true},
+		{name: "method", rule: &periscope.Rule{Method: "post"}, want: true},
+		{name: "other method", rule: &periscope.Rule{Method: "GET"}, want: false},
+		{name: "path", rule: &periscope.Rule{Path: "/api/*"}, want: true},
+		{name: "other path", rule: &periscope.Rule{Path: "/web/*"}, want: false},
+		{name: "header", rule: &periscope.Rule{Headers: []*periscope.Header{{Name: "X-Debug", Value: "me"}}}, want: true},
+		{name: "second value", rule: &periscope.Rule{Headers: []*periscope.Header{{Name: "X-Debug", Value: "you"}}}, want: true},
+		{name: "other value", rule: &periscope.Rule{Headers: []*periscope.Header{{Name: "X-Debug", Value: "them"}}}, want: false},
+		{name: "all headers", rule: &periscope.Rule{Headers: []*periscope.Header{{Name: "X-Debug", Value: "me"}, {Name: "X-Missing", Value: "x"}}}, want: false},
+		{name: "every percent", rule: &periscope.Rule{Path: "/api/*", Percent: 100}, want: true},
+	}
+	r := httptest.NewRequest(http.MethodPost, "http://svc/api/orders/1", nil)
+	r.Header.Add("X-Debug", "me")
+	r.Header.Add("X-Debug", "you")
+	for _, tt := range tests {
+		if got := matchRule(tt.rule, r); got != tt.want {
+			t.Errorf("%s: matchRule(%v) = %t, want %t", tt.name, tt.rule, got, tt.want)
+		}
+	}
+}
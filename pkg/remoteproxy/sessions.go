@@ -0,0 +1,126 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remoteproxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/evankanderson/periscope/pkg/periscope"
+)
+
+const (
+	// SessionHeader names the session a request out of the cluster is for.
+	SessionHeader = "X-Periscope-Session"
+	// SessionCookie does the same as SessionHeader, for browsers.
+	SessionCookie = "periscope-session"
+)
+
+// attach makes session the one named name, replacing any other.
+func (s *LocalProxy) attach(name string, session *periscope.Mux) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.sessions[name] != nil {
+		log.Printf("SESSION %q: replacing the connected session", name)
+	}
+	s.sessions[name] = session
+	close(s.attached)
+	s.attached = make(chan struct{})
+}
+
+func (s *LocalProxy) detach(name string, session *periscope.Mux) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.sessions[name] == session {
+		delete(s.sessions, name)
+	}
+}
+
+// sessionName picks the session for r: the one named by SessionHeader or
+// SessionCookie, else one named by the first label of its Host (as in
+// alice.myservice.example.com) if connected, else the default.
+func (s *LocalProxy) sessionName(r *http.Request) string {
+	if name := r.Header.Get(SessionHeader); name != "" {
+		return name
+	}
+	if c, err := r.Cookie(SessionCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if i := strings.Index(host, "."); i > 0 {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		if s.sessions[host[:i]] != nil {
+			return host[:i]
+		}
+	}
+	return s.defaultSession
+}
+
+// errQueueFull is returned by awaitSession when too many requests are
+// already waiting.
+var errQueueFull = errors.New("Too many requests waiting for a periscope session")
+
+// awaitSession returns the session named name. If it isn't connected, it
+// waits up to the grace window for it to attach, unless ctx ends first. It
+// returns nil if it doesn't.
+func (s *LocalProxy) awaitSession(ctx context.Context, name string) (*periscope.Mux, error) {
+	s.lock.Lock()
+	if session := s.sessions[name]; session != nil || s.grace <= 0 {
+		s.lock.Unlock()
+		return session, nil
+	}
+	if s.queueSize > 0 && s.waiting >= s.queueSize {
+		s.lock.Unlock()
+		return nil, errQueueFull
+	}
+	s.waiting++
+	attached := s.attached
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.waiting--
+	}()
+
+	timer := time.NewTimer(s.grace)
+	defer timer.Stop()
+	for {
+		select {
+		case <-attached:
+			s.lock.Lock()
+			session := s.sessions[name]
+			attached = s.attached
+			s.lock.Unlock()
+			if session != nil {
+				return session, nil
+			}
+		case <-timer.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
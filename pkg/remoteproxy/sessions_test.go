@@ -0,0 +1,150 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remoteproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/evankanderson/periscope/pkg/periscope"
+)
+
+// waitingFor waits for n requests to be waiting for a session.
+func (p *testProxy) waitingFor(t *testing.T, n int) {
+	t.Helper()
+	eventually(t, "requests to wait for a session", func() bool {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		return p.waiting == n
+	})
+}
+
+type result struct {
+	status int
+	body   string
+}
+
+// getLater sends a request for path to p in the background.
+func (p *testProxy) getLater(t *testing.T, path string) <-chan result {
+	results := make(chan result, 1)
+	go func() {
+		status, body, err := p.fetch(path, nil)
+		if err != nil {
+			t.Errorf("GET %s: %v", path, err)
+		}
+		results <- result{status, body}
+	}()
+	return results
+}
+
+func TestGraceAttach(t *testing.T) {
+	p := newTestProxy(t, Config{Grace: 5 * time.Second}, nil)
+	results := p.getLater(t, "/early")
+	p.waitingFor(t, 1)
+
+	p.connect(t, "", respond(http.StatusOK, "hello"))
+	select {
+	case got := <-results:
+		if got.status != http.StatusOK || got.body != "hello" {
+			t.Errorf("Got %d %q once the session attached, want %d %q", got.status, got.body, http.StatusOK, "hello")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the response")
+	}
+}
+
+func TestGraceQueueFull(t *testing.T) {
+	p := newTestProxy(t, Config{Grace: 5 * time.Second, QueueSize: 2}, nil)
+	first, second := p.getLater(t, "/1"), p.getLater(t, "/2")
+	p.waitingFor(t, 2)
+
+	// More are turned away without waiting.
+	start := time.Now()
+	status, body := p.get(t, "/3", nil)
+	if want := errQueueFull.Error() + "\n"; status != http.StatusServiceUnavailable || body != want {
+		t.Errorf("Got %d %q over the queue limit, want %d %q", status, body, http.StatusServiceUnavailable, want)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("The request over the queue limit took %s, want it turned away right away", elapsed)
+	}
+
+	// Those in the queue still get the session.
+	p.connect(t, "", respond(http.StatusOK, "hello"))
+	for _, results := range []<-chan result{first, second} {
+		select {
+		case got := <-results:
+			if got.status != http.StatusOK {
+				t.Errorf("Got %d %q for a queued request, want %d", got.status, got.body, http.StatusOK)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a queued request")
+		}
+	}
+	p.waitingFor(t, 0)
+}
+
+func TestGraceExpires(t *testing.T) {
+	const grace = 100 * time.Millisecond
+	p := newTestProxy(t, Config{Grace: grace}, nil)
+	start := time.Now()
+	status, body := p.get(t, "/late", nil)
+	if want := "No periscope session is connected\n"; status != http.StatusBadGateway || body != want {
+		t.Errorf("Got %d %q once the grace window ended, want %d %q", status, body, http.StatusBadGateway, want)
+	}
+	if elapsed := time.Since(start); elapsed < grace {
+		t.Errorf("The request failed after %s, before the %s grace window ended", elapsed, grace)
+	}
+	p.waitingFor(t, 0)
+}
+
+func TestSessionName(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		cookie string
+		host   string
+		want   string
+	}{
+		{name: "default", host: "svc.ns", want: "main"},
+		{name: "header", header: "carol", cookie: SessionCookie + "=alice", host: "alice.svc.ns", want: "carol"},
+		{name: "cookie", cookie: "theme=dark; " + SessionCookie + "=carol", host: "alice.svc.ns", want: "carol"},
+		{name: "empty cookie", cookie: SessionCookie + "=", host: "alice.svc.ns", want: "alice"},
+		{name: "host", host: "alice.svc.ns", want: "alice"},
+		{name: "host with port", host: "alice.svc.ns:8080", want: "alice"},
+		{name: "host of no session", host: "carol.svc.ns", want: "main"},
+		{name: "single label", host: "alice", want: "main"},
+	}
+	s, err := NewLocalProxy(Config{DefaultSession: "main"})
+	if err != nil {
+		t.Fatalf("NewLocalProxy: %v", err)
+	}
+	s.sessions["alice"] = &periscope.Mux{}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://"+tt.host+"/", nil)
+		if tt.header != "" {
+			r.Header.Set(SessionHeader, tt.header)
+		}
+		if tt.cookie != "" {
+			r.Header.Set("Cookie", tt.cookie)
+		}
+		if got := s.sessionName(r); got != tt.want {
+			t.Errorf("%s: sessionName() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
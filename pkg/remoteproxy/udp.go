@@ -0,0 +1,150 @@
+/*
+Copyright © 2021 Evan Anderson <Evan.K.Anderson@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package remoteproxy
+
+import (
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/evankanderson/periscope/pkg/periscope"
+)
+
+// udpSocket is the cluster end of a UDP session.
+type udpSocket struct {
+	conn       net.Conn
+	lastActive time.Time
+}
+
+// udpRelay holds the UDP sessions for a single Datagrams call.
+type udpRelay struct {
+	writer *periscope.Writer
+
+	lock    sync.Mutex
+	sockets map[int64]*udpSocket
+}
+
+func (s *LocalProxy) Datagrams(stream periscope.Periscope_DatagramsServer) error {
+	r := &udpRelay{
+		writer:  periscope.NewWriter(stream),
+		sockets: make(map[int64]*udpSocket),
+	}
+	defer r.expire(time.Time{})
+	go func() {
+		ticker := time.NewTicker(periscope.UDPIdleTimeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stream.Context().Done():
+				return
+			case now := <-ticker.C:
+				r.expire(now.Add(-periscope.UDPIdleTimeout))
+			}
+		}
+	}()
+
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		conn, err := r.socket(in)
+		if err != nil {
+			log.Printf("UDP %d: %s", in.Session, err)
+			continue
+		}
+		if _, err := conn.Write(in.Data); err != nil {
+			log.Printf("UDP %d: %s", in.Session, err)
+		}
+	}
+}
+
+// socket returns the socket for in's session, creating it if needed.
+func (r *udpRelay) socket(in *periscope.Datagram) (net.Conn, error) {
+	if conn := r.lookup(in.Session); conn != nil {
+		return conn, nil
+	}
+	// Dialing may look up a hostname, so don't hold up other sessions.
+	conn, err := net.Dial("udp", in.Address)
+	if err != nil {
+		return nil, err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if sock := r.sockets[in.Session]; sock != nil {
+		conn.Close()
+		sock.lastActive = time.Now()
+		return sock.conn, nil
+	}
+	log.Printf("UDP %d: %s", in.Session, in.Address)
+	r.sockets[in.Session] = &udpSocket{conn: conn, lastActive: time.Now()}
+	go r.replies(in.Session, in.Address, conn)
+	return conn, nil
+}
+
+// lookup returns the socket for session, if it has one.
+func (r *udpRelay) lookup(session int64) net.Conn {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if sock := r.sockets[session]; sock != nil {
+		sock.lastActive = time.Now()
+		return sock.conn
+	}
+	return nil
+}
+
+// replies relays datagrams received on conn back out, until it is closed.
+func (r *udpRelay) replies(session int64, address string, conn net.Conn) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		data := make([]byte, n)
+		copy(data, buf[:n])
+		r.touch(session)
+		if err := r.writer.Send(&periscope.Datagram{Session: session, Address: address, Data: data}); err != nil {
+			return
+		}
+	}
+}
+
+func (r *udpRelay) touch(session int64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if sock := r.sockets[session]; sock != nil {
+		sock.lastActive = time.Now()
+	}
+}
+
+// expire closes sessions last active before cutoff.
+func (r *udpRelay) expire(cutoff time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for session, sock := range r.sockets {
+		if cutoff.IsZero() || sock.lastActive.Before(cutoff) {
+			sock.conn.Close()
+			delete(r.sockets, session)
+		}
+	}
+}